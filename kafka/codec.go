@@ -0,0 +1,180 @@
+/*
+ * Copyright 2018-present Open Networking Foundation
+
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+
+ * http://www.apache.org/licenses/LICENSE-2.0
+
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package kafka
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/golang/protobuf/jsonpb"
+	"github.com/golang/protobuf/proto"
+	"gopkg.in/Shopify/sarama.v1"
+)
+
+// contentTypeFromHeaders returns the value of ContentTypeHeader on a consumed record, or "" if the record
+// carries no such header (e.g. it predates codec support).
+func contentTypeFromHeaders(headers []*sarama.RecordHeader) string {
+	for _, h := range headers {
+		if h != nil && string(h.Key) == ContentTypeHeader {
+			return string(h.Value)
+		}
+	}
+	return ""
+}
+
+// ContentTypeHeader is the Kafka record header key used to record which MessageCodec produced a record's
+// payload, so that a consumer reading a topic that is shared across adapters written in different languages
+// knows how to decode it.
+const ContentTypeHeader = "content-type"
+
+// MessageCodec marshals and unmarshals the payload carried on a Kafka record. Built-in implementations exist
+// for protobuf (the default, and the most compact), JSON (for topics that need to be human-debuggable), and
+// Avro with Confluent schema-registry lookup. Unmarshal decodes into whatever concrete proto.Message the caller
+// passes, so the same codec serves InterContainerMessage traffic and any other proto type registered against a
+// topic via RegisterMessageType.
+type MessageCodec interface {
+	// Marshal encodes msg into the wire representation for this codec.
+	Marshal(msg proto.Message) ([]byte, error)
+	// Unmarshal decodes data, produced by Marshal, into out.
+	Unmarshal(data []byte, out proto.Message) error
+	// ContentType is written to ContentTypeHeader on every record produced with this codec.
+	ContentType() string
+}
+
+// protoCodec is the default codec, and is wire-compatible with every existing topic.
+type protoCodec struct{}
+
+func (protoCodec) Marshal(msg proto.Message) ([]byte, error) {
+	return proto.Marshal(msg)
+}
+
+func (protoCodec) Unmarshal(data []byte, out proto.Message) error {
+	return proto.Unmarshal(data, out)
+}
+
+func (protoCodec) ContentType() string { return "application/x-protobuf" }
+
+// jsonCodec renders messages as human-readable JSON, using the proto field names and enum strings, so that
+// topics can be inspected with a plain Kafka console consumer.
+type jsonCodec struct {
+	marshaler   jsonpb.Marshaler
+	unmarshaler jsonpb.Unmarshaler
+}
+
+func newJSONCodec() *jsonCodec {
+	return &jsonCodec{
+		marshaler: jsonpb.Marshaler{EmitDefaults: false, OrigName: true},
+	}
+}
+
+func (c *jsonCodec) Marshal(msg proto.Message) ([]byte, error) {
+	s, err := c.marshaler.MarshalToString(msg)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(s), nil
+}
+
+func (c *jsonCodec) Unmarshal(data []byte, out proto.Message) error {
+	return c.unmarshaler.Unmarshal(bytes.NewReader(data), out)
+}
+
+func (c *jsonCodec) ContentType() string { return "application/json" }
+
+// SchemaRegistryClient resolves a Confluent schema-registry schema ID to the proto/Avro schema needed to decode
+// a payload, and registers new schemas to obtain an ID to encode with.
+type SchemaRegistryClient interface {
+	// SchemaID returns the registry ID for the given subject's current schema, registering it if needed.
+	SchemaID(subject string) (int, error)
+	// Codec returns a single-schema (de)serializer for the given registry ID.
+	Codec(id int) (avroSchemaCodec, error)
+}
+
+// avroSchemaCodec (de)serializes a payload under exactly one registered Avro schema.
+type avroSchemaCodec interface {
+	Marshal(msg proto.Message) ([]byte, error)
+	Unmarshal(data []byte, out proto.Message) error
+}
+
+// avroMagicByte is the leading byte of every Confluent-framed Avro record, identifying the wire format version.
+const avroMagicByte = 0x0
+
+// avroCodec implements the Confluent wire format: a magic byte, a 4-byte big-endian schema ID, then the
+// Avro-encoded payload. The schema ID is looked up/registered against registry for the given subject.
+type avroCodec struct {
+	registry SchemaRegistryClient
+	subject  string
+}
+
+// NewAvroCodec creates a MessageCodec that looks up/registers its schema against a Confluent-style schema
+// registry under the given subject name.
+func NewAvroCodec(registry SchemaRegistryClient, subject string) MessageCodec {
+	return &avroCodec{registry: registry, subject: subject}
+}
+
+func (c *avroCodec) Marshal(msg proto.Message) ([]byte, error) {
+	id, err := c.registry.SchemaID(c.subject)
+	if err != nil {
+		return nil, err
+	}
+	schemaCodec, err := c.registry.Codec(id)
+	if err != nil {
+		return nil, err
+	}
+	payload, err := schemaCodec.Marshal(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]byte, 5+len(payload))
+	out[0] = avroMagicByte
+	binary.BigEndian.PutUint32(out[1:5], uint32(id))
+	copy(out[5:], payload)
+	return out, nil
+}
+
+func (c *avroCodec) Unmarshal(data []byte, out proto.Message) error {
+	if len(data) < 5 || data[0] != avroMagicByte {
+		return errors.New("invalid-confluent-avro-frame")
+	}
+	id := int(binary.BigEndian.Uint32(data[1:5]))
+	schemaCodec, err := c.registry.Codec(id)
+	if err != nil {
+		return fmt.Errorf("unknown-schema-id-%d: %w", id, err)
+	}
+	return schemaCodec.Unmarshal(data[5:], out)
+}
+
+func (c *avroCodec) ContentType() string { return "application/avro" }
+
+// codecByContentType resolves the codec that produced a record, falling back to the client's configured
+// default codec for legacy records with no content-type header at all.
+func (sc *SaramaClient) codecByContentType(contentType string) MessageCodec {
+	if contentType == "" {
+		return sc.codec
+	}
+	switch contentType {
+	case protoCodec{}.ContentType():
+		return protoCodec{}
+	case newJSONCodec().ContentType():
+		return newJSONCodec()
+	default:
+		// Topics sharing an Avro schema registry subject reuse sc.codec, which already knows the registry.
+		return sc.codec
+	}
+}
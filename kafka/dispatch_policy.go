@@ -0,0 +1,138 @@
+/*
+ * Copyright 2018-present Open Networking Foundation
+
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+
+ * http://www.apache.org/licenses/LICENSE-2.0
+
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package kafka
+
+import "sync/atomic"
+
+// DispatchPolicy controls what dispatchToConsumers does when a subscriber's bounded channel is already full of
+// undelivered messages.
+type DispatchPolicy int
+
+const (
+	// Block waits for room in the subscriber's channel. This is the original, pre-chunk1-3 behaviour: a slow
+	// subscriber only ever delays itself, never other subscribers of the same topic, since each gets dispatched
+	// independently.
+	Block DispatchPolicy = iota
+	// DropOldest discards the subscriber's oldest undelivered message to make room for the newest one.
+	DropOldest
+	// DropNewest discards the incoming message rather than displacing anything already queued for the subscriber.
+	DropNewest
+	// DisconnectSlow closes and unsubscribes a channel that is already full, rather than dropping individual
+	// messages. Use this for subscribers where a gap in the stream is worse than losing the subscription.
+	DisconnectSlow
+)
+
+func (p DispatchPolicy) String() string {
+	switch p {
+	case Block:
+		return "block"
+	case DropOldest:
+		return "drop-oldest"
+	case DropNewest:
+		return "drop-newest"
+	case DisconnectSlow:
+		return "disconnect-slow"
+	default:
+		return "unknown"
+	}
+}
+
+// subscriberChannel is the bookkeeping the dispatcher keeps for one channel returned by Subscribe: the channel
+// itself, the policy to apply when it is full, and counters a caller can inspect to tell whether it is falling
+// behind.
+type subscriberChannel struct {
+	ch      chan *Envelope
+	policy  DispatchPolicy
+	dropped uint64
+	stop    chan struct{}
+}
+
+func newSubscriberChannel(bufferSize int, policy DispatchPolicy) *subscriberChannel {
+	if bufferSize < 1 {
+		bufferSize = 1
+	}
+	return &subscriberChannel{
+		ch:     make(chan *Envelope, bufferSize),
+		policy: policy,
+		stop:   make(chan struct{}),
+	}
+}
+
+// Dropped returns the number of messages this subscriber has lost to a DropOldest/DropNewest policy firing.  It
+// is always zero for Block and DisconnectSlow subscribers, since neither ever drops a message.
+func (s *subscriberChannel) Dropped() uint64 {
+	return atomic.LoadUint64(&s.dropped)
+}
+
+// close tears down this subscriber's channel, always closing stop before ch. A goroutine parked in dispatch's
+// Block case is selecting on both, so closing ch first would race a blocked send against the close and panic
+// with "send on closed channel"; closing stop first wakes that select via its stop case instead. Every call site
+// that removes a subscriber - disconnectSubscriber, removeChannel, clearTopicFromConsumerChannelMap,
+// clearConsumerChannelMap - must close a subscriber through this method rather than closing ch directly.
+func (s *subscriberChannel) close() {
+	close(s.stop)
+	close(s.ch)
+}
+
+// dispatch delivers envelope to this subscriber according to its policy. It never blocks the caller for longer
+// than the subscriber's own Block policy says it should, so a topic's other subscribers are never held up by one
+// slow one. It returns false if the subscriber should be disconnected as a result of this delivery attempt, or if
+// it already was by a concurrent disconnectSubscriber/watchSubscriber call while this send was blocked.
+func (s *subscriberChannel) dispatch(envelope *Envelope) bool {
+	switch s.policy {
+	case Block:
+		select {
+		case s.ch <- envelope:
+			return true
+		case <-s.stop:
+			return false
+		}
+	case DropOldest:
+		for {
+			select {
+			case s.ch <- envelope:
+				return true
+			default:
+			}
+			select {
+			case <-s.ch:
+				atomic.AddUint64(&s.dropped, 1)
+			default:
+			}
+		}
+	case DropNewest:
+		select {
+		case s.ch <- envelope:
+		default:
+			atomic.AddUint64(&s.dropped, 1)
+		}
+		return true
+	case DisconnectSlow:
+		select {
+		case s.ch <- envelope:
+			return true
+		default:
+			return false
+		}
+	default:
+		select {
+		case s.ch <- envelope:
+			return true
+		case <-s.stop:
+			return false
+		}
+	}
+}
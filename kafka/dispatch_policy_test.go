@@ -0,0 +1,67 @@
+/*
+ * Copyright 2018-present Open Networking Foundation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package kafka
+
+import (
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// TestDispatchToConsumersDoesNotLeakGoroutinesOnStalledSubscriber reproduces the goroutine leak this dispatcher
+// was meant to fix: dispatchToConsumers spawns one goroutine per message and, within it, one goroutine per
+// subscriber, waiting for every delivery to complete before returning. A Block subscriber whose reader has
+// stopped draining its channel used to block that delivery goroutine - and the per-message goroutine waiting on
+// it - forever, pinning one more goroutine pair per message indefinitely. With DefaultLivenessTimeout wired in as
+// every subscriber's default, watchSubscriber now disconnects a subscriber that stays full for that long, so the
+// goroutine count stays bounded instead of growing without limit.
+func TestDispatchToConsumersDoesNotLeakGoroutinesOnStalledSubscriber(t *testing.T) {
+	sc := &SaramaClient{
+		topicToConsumerChannelMap: make(map[string]*consumerChannels),
+		doneCh:                    make(chan int, 1),
+		instrumentation:           newInstrumentation(prometheus.NewRegistry()),
+	}
+
+	const key = "stress-topic"
+	consumerCh := &consumerChannels{topicName: key}
+	sc.topicToConsumerChannelMap[key] = consumerCh
+
+	// A buffer of 1 and no reader means the first dispatched message fills it and every one after that finds it
+	// full, the condition that used to wedge a Block subscriber's delivery goroutine forever.
+	sub := newSubscriberChannel(1, Block)
+	consumerCh.channels = append(consumerCh.channels, sub)
+	go sc.watchSubscriber(key, sub, 50*time.Millisecond)
+
+	before := runtime.NumGoroutine()
+
+	for i := 0; i < 2000; i++ {
+		go sc.dispatchToConsumers(consumerCh, &Envelope{})
+	}
+
+	// Give the watchdog time to notice the stalled subscriber and disconnect it, and every dispatchToConsumers
+	// call blocked on it time to unwind once it does.
+	deadline := time.Now().Add(5 * time.Second)
+	for runtime.NumGoroutine() > before+10 && time.Now().Before(deadline) {
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	if after := runtime.NumGoroutine(); after > before+10 {
+		t.Fatalf("goroutine count grew from %d to %d after dispatching to a stalled subscriber; watchSubscriber should have disconnected it and let every pending dispatch unwind", before, after)
+	}
+}
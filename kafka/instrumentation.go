@@ -0,0 +1,156 @@
+/*
+ * Copyright 2018-present Open Networking Foundation
+
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+
+ * http://www.apache.org/licenses/LICENSE-2.0
+
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package kafka
+
+import (
+	"errors"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// instrumentationNamespace/Subsystem group every collector this package registers under
+// voltha_kafka_<name> so they sit next to the rest of a VOLTHA component's metrics.
+const (
+	instrumentationNamespace = "voltha"
+	instrumentationSubsystem = "kafka"
+)
+
+// Instrumentation holds every Prometheus collector the Kafka client reports.
+type Instrumentation struct {
+	messagesSent          prometheus.Counter
+	sendErrors            prometheus.Counter
+	messagesReceived      prometheus.Counter
+	unmarshalErrors       prometheus.Counter
+	dispatchLatency       prometheus.Histogram
+	subscriberDepth       *prometheus.GaugeVec
+	consumerLag           *prometheus.GaugeVec
+	subscriberDisconnects *prometheus.CounterVec
+}
+
+// newInstrumentation builds the collector set and registers it against registerer, falling back to the global
+// prometheus.DefaultRegisterer if the caller did not supply one via WithRegisterer.
+func newInstrumentation(registerer prometheus.Registerer) *Instrumentation {
+	if registerer == nil {
+		registerer = prometheus.DefaultRegisterer
+	}
+
+	inst := &Instrumentation{
+		messagesSent: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: instrumentationNamespace,
+			Subsystem: instrumentationSubsystem,
+			Name:      "messages_sent_total",
+			Help:      "Total number of messages successfully published to Kafka.",
+		}),
+		sendErrors: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: instrumentationNamespace,
+			Subsystem: instrumentationSubsystem,
+			Name:      "send_errors_total",
+			Help:      "Total number of publish attempts that the broker rejected.",
+		}),
+		messagesReceived: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: instrumentationNamespace,
+			Subsystem: instrumentationSubsystem,
+			Name:      "messages_received_total",
+			Help:      "Total number of messages consumed and successfully decoded from Kafka.",
+		}),
+		unmarshalErrors: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: instrumentationNamespace,
+			Subsystem: instrumentationSubsystem,
+			Name:      "unmarshal_errors_total",
+			Help:      "Total number of consumed records that failed to decode.",
+		}),
+		dispatchLatency: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: instrumentationNamespace,
+			Subsystem: instrumentationSubsystem,
+			Name:      "dispatch_latency_seconds",
+			Help:      "Time taken to hand a consumed message to every subscriber of its topic.",
+			Buckets:   prometheus.DefBuckets,
+		}),
+		subscriberDepth: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: instrumentationNamespace,
+			Subsystem: instrumentationSubsystem,
+			Name:      "subscriber_channel_depth",
+			Help:      "Number of undelivered messages currently queued for a subscriber channel.",
+		}, []string{"topic"}),
+		consumerLag: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: instrumentationNamespace,
+			Subsystem: instrumentationSubsystem,
+			Name:      "consumer_lag",
+			Help:      "Difference between a partition's newest offset and the last offset this client has consumed.",
+		}, []string{"topic", "partition"}),
+		subscriberDisconnects: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: instrumentationNamespace,
+			Subsystem: instrumentationSubsystem,
+			Name:      "subscriber_disconnects_total",
+			Help:      "Total number of subscriber channels closed and removed because they could not keep up, by reason.",
+		}, []string{"topic", "reason"}),
+	}
+
+	// A collector already registered under the same name (e.g. two SaramaClients sharing a registerer via
+	// WithRegisterer) is not an error worth failing Start() over, but this instance must then report through the
+	// existing collector rather than its own, unregistered one, or its counters/gauges would silently update an
+	// object the shared registerer never scrapes while the one actually exposed - the first client's - never
+	// moves. registerOrReuse swaps in are.ExistingCollector whenever Register reports that collision.
+	inst.messagesSent = registerOrReuse(registerer, inst.messagesSent).(prometheus.Counter)
+	inst.sendErrors = registerOrReuse(registerer, inst.sendErrors).(prometheus.Counter)
+	inst.messagesReceived = registerOrReuse(registerer, inst.messagesReceived).(prometheus.Counter)
+	inst.unmarshalErrors = registerOrReuse(registerer, inst.unmarshalErrors).(prometheus.Counter)
+	inst.dispatchLatency = registerOrReuse(registerer, inst.dispatchLatency).(prometheus.Histogram)
+	inst.subscriberDepth = registerOrReuse(registerer, inst.subscriberDepth).(*prometheus.GaugeVec)
+	inst.consumerLag = registerOrReuse(registerer, inst.consumerLag).(*prometheus.GaugeVec)
+	inst.subscriberDisconnects = registerOrReuse(registerer, inst.subscriberDisconnects).(*prometheus.CounterVec)
+
+	return inst
+}
+
+// registerOrReuse registers collector against registerer and returns it, unless a collector of the same name was
+// already registered, in which case it returns that existing collector instead. This lets multiple SaramaClients
+// sharing a registerer (via WithRegisterer) genuinely aggregate onto one set of collectors rather than each
+// silently updating its own, never-scraped copy.
+func registerOrReuse(registerer prometheus.Registerer, collector prometheus.Collector) prometheus.Collector {
+	err := registerer.Register(collector)
+	if err == nil {
+		return collector
+	}
+	are := new(prometheus.AlreadyRegisteredError)
+	if errors.As(err, are) {
+		return are.ExistingCollector
+	}
+	return collector
+}
+
+// Healthy reports whether the client can currently reach the Kafka broker. Wire it into a liveness probe.
+func (sc *SaramaClient) Healthy() error {
+	if sc.cAdmin == nil {
+		return errors.New("kafka-client-not-started")
+	}
+	if _, err := sc.cAdmin.Controller(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Ready reports whether the client has finished starting up and can send and receive messages. Wire it into a
+// readiness probe.
+func (sc *SaramaClient) Ready() error {
+	if err := sc.Healthy(); err != nil {
+		return err
+	}
+	if sc.producer == nil || sc.consumer == nil {
+		return errors.New("kafka-client-not-fully-initialized")
+	}
+	return nil
+}
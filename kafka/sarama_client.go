@@ -16,31 +16,70 @@
 package kafka
 
 import (
+	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
-	scc "github.com/bsm/sarama-cluster"
 	"github.com/golang/protobuf/proto"
 	"github.com/google/uuid"
 	"github.com/opencord/voltha-go/common/log"
 	ca "github.com/opencord/voltha-go/protos/core_adapter"
+	"github.com/prometheus/client_golang/prometheus"
 	"gopkg.in/Shopify/sarama.v1"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 )
 
+// defaultGroupConsumeRetryBackoff is the initial delay before a GroupCustomer subscription re-invokes
+// ConsumerGroup.Consume after a session ends in error, doubling on each consecutive failure up to
+// maxGroupConsumeRetryBackoff. A clean session end (a rebalance) is not an error and is retried immediately;
+// sc.doneCh firing ends the consumption loop instead.
+const defaultGroupConsumeRetryBackoff = 500 * time.Millisecond
+const maxGroupConsumeRetryBackoff = 30 * time.Second
+
 func init() {
 	log.AddPackage(log.JSON, log.WarnLevel, nil)
 }
 
 type returnErrorFunction func() error
 
+// Envelope wraps a decoded message together with the codec that decoded it, so that a subscriber consuming a
+// topic shared across adapters written in different languages can tell what it received. Message holds whatever
+// concrete proto.Message type RegisterMessageType associated with the topic, or *ca.InterContainerMessage if
+// none was registered. Callers that only ever handled InterContainer traffic can use AsInterContainerMessage to
+// adapt without auditing every call site for the new generic type.
+type Envelope struct {
+	Message proto.Message
+	Codec   MessageCodec
+}
+
+// AsInterContainerMessage type-asserts an Envelope.Message back to *ca.InterContainerMessage, for subscribers
+// written before Subscribe channels carried arbitrary proto.Message payloads. It fails if the topic's message
+// type was registered to something else via RegisterMessageType.
+func AsInterContainerMessage(msg proto.Message) (*ca.InterContainerMessage, error) {
+	icm, ok := msg.(*ca.InterContainerMessage)
+	if !ok {
+		return nil, fmt.Errorf("not-an-intercontainer-message: %T", msg)
+	}
+	return icm, nil
+}
+
 // consumerChannels represents one or more consumers listening on a kafka topic.  Once a message is received on that
 // topic, the consumer(s) broadcasts the message to all the listening channels.   The consumer can be a partition
 //consumer or a group consumer
 type consumerChannels struct {
+	topicName string
 	consumers []interface{}
-	channels  []chan *ca.InterContainerMessage
+	channels  []*subscriberChannel
+
+	// partitionConsumers and offsetManager are only populated for a PartitionConsumer mode subscription, letting
+	// reconcilePartitions tell which partitions of topicName it still needs to pick up and resume each one from
+	// the offset last committed for it rather than opts.InitialOffset. Both are nil for a GroupCustomer
+	// subscription, whose partitions and offsets sarama's ConsumerGroup already manages.
+	partitionConsumers map[int32]sarama.PartitionConsumer
+	offsetManager      sarama.OffsetManager
 }
 
 // SaramaClient represents the messaging proxy
@@ -51,7 +90,7 @@ type SaramaClient struct {
 	KafkaPort                     int
 	producer                      sarama.AsyncProducer
 	consumer                      sarama.Consumer
-	groupConsumer                 *scc.Consumer
+	groupConsumers                map[string]sarama.ConsumerGroup
 	consumerType                  int
 	groupName                     string
 	producerFlushFrequency        int
@@ -69,6 +108,19 @@ type SaramaClient struct {
 	doneCh                        chan int
 	topicToConsumerChannelMap     map[string]*consumerChannels
 	lockTopicToConsumerChannelMap sync.RWMutex
+	codec                         MessageCodec
+	deadLetterTopic               *Topic
+	producerDeliveryMap           map[string]chan error
+	lockProducerDeliveryMap       sync.Mutex
+	registerer                    prometheus.Registerer
+	instrumentation               *Instrumentation
+	brokers                       []string
+	tlsConfig                     *tls.Config
+	saslMechanism                 SASLMechanism
+	saslUser                      string
+	saslPassword                  string
+	messageTypeRegistry           map[string]func() proto.Message
+	lockMessageTypeRegistry       sync.RWMutex
 }
 
 type SaramaClientOption func(*SaramaClient)
@@ -151,6 +203,57 @@ func AutoCreateTopic(opt bool) SaramaClientOption {
 	}
 }
 
+// WithCodec selects the MessageCodec used to marshal outgoing messages and to unmarshal incoming messages whose
+// content-type header is absent or unrecognized. Defaults to the protobuf codec.
+func WithCodec(codec MessageCodec) SaramaClientOption {
+	return func(args *SaramaClient) {
+		args.codec = codec
+	}
+}
+
+// DeadLetterTopic selects the topic that SendSync republishes a message to once its first attempt and all
+// producerRetryMax retries have failed, tagged with DeadLetterReasonHeader/DeadLetterOriginalTopicHeader. Unset by default,
+// meaning a permanently-failed SendSync simply returns the last error.
+func DeadLetterTopic(topic *Topic) SaramaClientOption {
+	return func(args *SaramaClient) {
+		args.deadLetterTopic = topic
+	}
+}
+
+// WithRegisterer selects the prometheus.Registerer the client's Instrumentation collectors are registered
+// against, in place of prometheus.DefaultRegisterer.
+func WithRegisterer(registerer prometheus.Registerer) SaramaClientOption {
+	return func(args *SaramaClient) {
+		args.registerer = registerer
+	}
+}
+
+// Brokers sets the full bootstrap broker list the client connects to, in place of the single KafkaHost:KafkaPort
+// address, so the client can reach a multi-broker cluster.
+func Brokers(brokers []string) SaramaClientOption {
+	return func(args *SaramaClient) {
+		args.brokers = brokers
+	}
+}
+
+// TLSConfig enables TLS on every connection the client makes, using tlsConfig to carry the broker's CA and, if
+// required, a client certificate.
+func TLSConfig(tlsConfig *tls.Config) SaramaClientOption {
+	return func(args *SaramaClient) {
+		args.tlsConfig = tlsConfig
+	}
+}
+
+// SASL enables SASL authentication on every connection the client makes, using mechanism (PLAIN, SCRAM-SHA-256,
+// or SCRAM-SHA-512) to authenticate as user/password.
+func SASL(mechanism SASLMechanism, user, password string) SaramaClientOption {
+	return func(args *SaramaClient) {
+		args.saslMechanism = mechanism
+		args.saslUser = user
+		args.saslPassword = password
+	}
+}
+
 func NewSaramaClient(opts ...SaramaClientOption) *SaramaClient {
 	client := &SaramaClient{
 		KafkaHost: DefaultKafkaHost,
@@ -169,6 +272,8 @@ func NewSaramaClient(opts ...SaramaClientOption) *SaramaClient {
 	client.numPartitions = DefaultNumberPartitions
 	client.numReplicas = DefaultNumberReplicas
 	client.autoCreateTopic = DefaultAutoCreateTopic
+	client.codec = protoCodec{}
+	client.messageTypeRegistry = make(map[string]func() proto.Message)
 
 	for _, option := range opts {
 		option(client)
@@ -185,6 +290,8 @@ func (sc *SaramaClient) Start() error {
 	// Create the Done channel
 	sc.doneCh = make(chan int, 1)
 
+	sc.instrumentation = newInstrumentation(sc.registerer)
+
 	var err error
 
 	// Create the Cluster Admin
@@ -193,6 +300,12 @@ func (sc *SaramaClient) Start() error {
 		return err
 	}
 
+	// Create the client used to look up partition offsets for consumer lag reporting
+	if err = sc.createClient(); err != nil {
+		log.Errorw("Cannot-create-kafka-client", log.Fields{"error": err})
+		return err
+	}
+
 	// Create the Publisher
 	if err := sc.createPublisher(); err != nil {
 		log.Errorw("Cannot-create-kafka-publisher", log.Fields{"error": err})
@@ -207,6 +320,12 @@ func (sc *SaramaClient) Start() error {
 
 	// Create the topic to consumers/channel map
 	sc.topicToConsumerChannelMap = make(map[string]*consumerChannels)
+	sc.groupConsumers = make(map[string]sarama.ConsumerGroup)
+	sc.producerDeliveryMap = make(map[string]chan error)
+
+	// Drain the producer's Successes()/Errors() channels for the lifetime of the client: SendSync correlates a
+	// reply off them, and Send's fire-and-forget messages must still be drained or the producer itself blocks.
+	go sc.drainProducerResults()
 
 	return nil
 }
@@ -214,8 +333,11 @@ func (sc *SaramaClient) Start() error {
 func (sc *SaramaClient) Stop() {
 	log.Info("stopping-sarama-client")
 
-	//Send a message over the done channel to close all long running routines
-	sc.doneCh <- 1
+	// Close, rather than send on, doneCh: every long running goroutine - drainProducerResults,
+	// consumeFromAPartition, watchSubscriber, consumeGroupMessages's cancel relay, reconcilePartitions - selects
+	// on <-sc.doneCh to know when to exit, and a single send only ever wakes one of them. Closing broadcasts to
+	// all of them at once.
+	close(sc.doneCh)
 
 	if sc.producer != nil {
 		if err := sc.producer.Close(); err != nil {
@@ -229,9 +351,9 @@ func (sc *SaramaClient) Stop() {
 		}
 	}
 
-	if sc.groupConsumer != nil {
-		if err := sc.groupConsumer.Close(); err != nil {
-			panic(err)
+	for key, groupConsumer := range sc.groupConsumers {
+		if err := groupConsumer.Close(); err != nil {
+			log.Warnw("failed-to-close-group-consumer", log.Fields{"key": key, "error": err})
 		}
 	}
 
@@ -241,6 +363,10 @@ func (sc *SaramaClient) Stop() {
 		}
 	}
 
+	if sc.client != nil {
+		sc.client.Close()
+	}
+
 	//TODO: Clear the consumers map
 	sc.clearConsumerChannelMap()
 
@@ -293,45 +419,76 @@ func (sc *SaramaClient) DeleteTopic(topic *Topic) error {
 	return nil
 }
 
+// RegisterMessageType associates topic with the proto.Message type newMessage produces, so that this client's
+// consumer loops decode records consumed from topic into that type instead of assuming
+// *ca.InterContainerMessage. Call it before Subscribe-ing to topic; it has no effect on a subscription already
+// in progress.
+func (sc *SaramaClient) RegisterMessageType(topic *Topic, newMessage func() proto.Message) {
+	sc.lockMessageTypeRegistry.Lock()
+	defer sc.lockMessageTypeRegistry.Unlock()
+	sc.messageTypeRegistry[topic.Name] = newMessage
+}
+
+// messageFactory returns a constructor for the proto.Message type registered against topicName via
+// RegisterMessageType, or one producing *ca.InterContainerMessage if none was registered, preserving the
+// behaviour every topic had before message types became registrable.
+func (sc *SaramaClient) messageFactory(topicName string) func() proto.Message {
+	sc.lockMessageTypeRegistry.RLock()
+	defer sc.lockMessageTypeRegistry.RUnlock()
+	if newMessage, ok := sc.messageTypeRegistry[topicName]; ok {
+		return newMessage
+	}
+	return func() proto.Message { return &ca.InterContainerMessage{} }
+}
+
 // Subscribe registers a caller to a topic. It returns a channel that the caller can use to receive
-// messages from that topic
-func (sc *SaramaClient) Subscribe(topic *Topic) (<-chan *ca.InterContainerMessage, error) {
-	log.Debugw("subscribe", log.Fields{"topic": topic.Name})
+// messages from that topic. By default the subscription uses the client-wide consumerType and the
+// DefaultGroupName group id; pass SubscribeOptions to run under a different group id (or a different consumer
+// type, offset, session timeout, rebalance strategy, or dispatch concurrency) than other subscribers of the
+// same topic.
+func (sc *SaramaClient) Subscribe(topic *Topic, options ...SubscribeOption) (<-chan *Envelope, error) {
+	opts := sc.defaultSubscribeOptions()
+	for _, option := range options {
+		option(&opts)
+	}
+	key := consumerChannelKey(topic, opts)
+	log.Debugw("subscribe", log.Fields{"topic": topic.Name, "group-id": opts.GroupId, "consumer-type": opts.ConsumerType})
 
-	// If a consumers already exist for that topic then resuse it
-	if consumerCh := sc.getConsumerChannel(topic); consumerCh != nil {
-		log.Debugw("topic-already-subscribed", log.Fields{"topic": topic.Name})
+	// If a consumers already exist for that topic/group then reuse it
+	if consumerCh := sc.getConsumerChannel(key); consumerCh != nil {
+		log.Debugw("topic-already-subscribed", log.Fields{"topic": topic.Name, "group-id": opts.GroupId})
 		// Create a channel specific for that consumers and add it to the consumers channel map
-		ch := make(chan *ca.InterContainerMessage)
-		sc.addChannelToConsumerChannelMap(topic, ch)
-		return ch, nil
+		sub := newSubscriberChannel(opts.MaxInFlight, opts.DispatchPolicy)
+		sc.addChannelToConsumerChannelMap(key, sub)
+		go sc.watchSubscriber(key, sub, opts.LivenessTimeout)
+		return sub.ch, nil
 	}
 
 	// Register for the topic and set it up
-	var consumerListeningChannel chan *ca.InterContainerMessage
+	var consumerListeningChannel chan *Envelope
 	var err error
 
 	// Use the consumerType option to figure out the type of consumer to launch
-	if sc.consumerType == PartitionConsumer {
+	if opts.ConsumerType == PartitionConsumer {
 		if sc.autoCreateTopic {
 			if err = sc.CreateTopic(topic, sc.numPartitions, sc.numReplicas); err != nil {
 				log.Errorw("create-topic-failure", log.Fields{"error": err, "topic": topic.Name})
 				return nil, err
 			}
 		}
-		if consumerListeningChannel, err = sc.setupPartitionConsumerChannel(topic, sarama.OffsetNewest); err != nil {
+		if consumerListeningChannel, err = sc.setupPartitionConsumerChannel(topic, key, opts); err != nil {
 			log.Warnw("create-consumers-channel-failure", log.Fields{"error": err, "topic": topic.Name})
 			return nil, err
 		}
-	} else if sc.consumerType == GroupCustomer {
+	} else if opts.ConsumerType == GroupCustomer {
 		// TODO: create topic if auto create is on.  There is an issue with the sarama cluster library that
 		// does not consume from a precreated topic in some scenarios
-		if consumerListeningChannel, err = sc.setupGroupConsumerChannel(topic, "mytest"); err != nil {
+		if consumerListeningChannel, err = sc.setupGroupConsumerChannel(topic, key, opts); err != nil {
 			log.Warnw("create-consumers-channel-failure", log.Fields{"error": err, "topic": topic.Name})
 			return nil, err
 		}
 	} else {
-		log.Warnw("unknown-consumer-type", log.Fields{"consumer-type": sc.consumerType})
+		log.Warnw("unknown-consumer-type", log.Fields{"consumer-type": opts.ConsumerType})
 		return nil, errors.New("unknown-consumer-type")
 	}
 
@@ -339,29 +496,53 @@ func (sc *SaramaClient) Subscribe(topic *Topic) (<-chan *ca.InterContainerMessag
 }
 
 //UnSubscribe unsubscribe a consumer from a given topic
-func (sc *SaramaClient) UnSubscribe(topic *Topic, ch <-chan *ca.InterContainerMessage) error {
+func (sc *SaramaClient) UnSubscribe(topic *Topic, ch <-chan *Envelope, options ...SubscribeOption) error {
 	log.Debugw("unsubscribing-channel-from-topic", log.Fields{"topic": topic.Name})
-	err := sc.removeChannelFromConsumerChannelMap(*topic, ch)
+	opts := sc.defaultSubscribeOptions()
+	for _, option := range options {
+		option(&opts)
+	}
+	err := sc.removeChannelFromConsumerChannelMap(consumerChannelKey(topic, opts), ch)
 	return err
 }
 
+// DroppedCount reports how many messages have been discarded for a subscriber channel returned by Subscribe, as
+// a result of its DropOldest or DropNewest DispatchPolicy firing. It is always zero for Block and DisconnectSlow
+// subscribers. A channel's current backlog can be read directly off the channel itself with len(ch).
+func (sc *SaramaClient) DroppedCount(topic *Topic, ch <-chan *Envelope, options ...SubscribeOption) uint64 {
+	opts := sc.defaultSubscribeOptions()
+	for _, option := range options {
+		option(&opts)
+	}
+	consumerCh := sc.getConsumerChannel(consumerChannelKey(topic, opts))
+	if consumerCh == nil {
+		return 0
+	}
+	sc.lockTopicToConsumerChannelMap.Lock()
+	defer sc.lockTopicToConsumerChannelMap.Unlock()
+	for _, sub := range consumerCh.channels {
+		if sub.ch == ch {
+			return sub.Dropped()
+		}
+	}
+	return 0
+}
+
 // send formats and sends the request onto the kafka messaging bus.
 func (sc *SaramaClient) Send(msg interface{}, topic *Topic, keys ...string) error {
 
-	// Assert message is a proto message
-	var protoMsg proto.Message
-	var ok bool
-	// ascertain the value interface type is a proto.Message
-	if protoMsg, ok = msg.(proto.Message); !ok {
-		log.Warnw("message-not-proto-message", log.Fields{"msg": msg})
-		return errors.New(fmt.Sprintf("not-a-proto-msg-%s", msg))
+	// Every codec marshals a proto.Message, not just *ca.InterContainerMessage.
+	icm, ok := msg.(proto.Message)
+	if !ok {
+		log.Warnw("message-not-a-proto-message", log.Fields{"msg": msg})
+		return errors.New(fmt.Sprintf("not-a-proto-message-%s", msg))
 	}
 
 	var marshalled []byte
 	var err error
 	//	Create the Sarama producer message
-	if marshalled, err = proto.Marshal(protoMsg); err != nil {
-		log.Errorw("marshalling-failed", log.Fields{"msg": protoMsg, "error": err})
+	if marshalled, err = sc.codec.Marshal(icm); err != nil {
+		log.Errorw("marshalling-failed", log.Fields{"msg": icm, "error": err})
 		return err
 	}
 	key := ""
@@ -372,6 +553,9 @@ func (sc *SaramaClient) Send(msg interface{}, topic *Topic, keys ...string) erro
 		Topic: topic.Name,
 		Key:   sarama.StringEncoder(key),
 		Value: sarama.ByteEncoder(marshalled),
+		Headers: []sarama.RecordHeader{
+			{Key: []byte(ContentTypeHeader), Value: []byte(sc.codec.ContentType())},
+		},
 	}
 
 	// Send message to kafka
@@ -379,22 +563,225 @@ func (sc *SaramaClient) Send(msg interface{}, topic *Topic, keys ...string) erro
 	return nil
 }
 
+// DeadLetterReasonHeader carries the error that made SendSync give up on a message, on the copy republished to
+// the DeadLetterTopic.
+const DeadLetterReasonHeader = "dead-letter-reason"
+
+// DeadLetterOriginalTopicHeader carries the topic a dead-lettered message was originally addressed to.
+const DeadLetterOriginalTopicHeader = "dead-letter-original-topic"
+
+// SendSync formats and sends the request onto the kafka messaging bus the same way Send does, but blocks until
+// the broker has acknowledged the record (or rejected it), retrying up to producerRetryMax times with
+// exponential backoff starting at producerRetryBackOff. If every attempt fails and a DeadLetterTopic is
+// configured, the message is republished there with DeadLetterReasonHeader/DeadLetterOriginalTopicHeader set,
+// and the original error is still returned to the caller.
+func (sc *SaramaClient) SendSync(ctx context.Context, msg interface{}, topic *Topic, keys ...string) error {
+	icm, ok := msg.(proto.Message)
+	if !ok {
+		log.Warnw("message-not-a-proto-message", log.Fields{"msg": msg})
+		return errors.New(fmt.Sprintf("not-a-proto-message-%s", msg))
+	}
+
+	marshalled, err := sc.codec.Marshal(icm)
+	if err != nil {
+		log.Errorw("marshalling-failed", log.Fields{"msg": icm, "error": err})
+		return err
+	}
+	key := ""
+	if len(keys) > 0 {
+		key = keys[0] // Only the first key is relevant
+	}
+
+	backoff := sc.producerRetryBackOff
+	var lastErr error
+	for attempt := 0; attempt <= sc.producerRetryMax; attempt++ {
+		if lastErr = sc.sendAndWait(ctx, topic, key, marshalled, sc.codec.ContentType()); lastErr == nil {
+			return nil
+		}
+		log.Warnw("send-sync-attempt-failed", log.Fields{"topic": topic.Name, "attempt": attempt, "error": lastErr})
+		if attempt == sc.producerRetryMax {
+			break
+		}
+		select {
+		case <-time.After(backoff):
+			backoff *= 2
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	if sc.deadLetterTopic != nil {
+		sc.sendToDeadLetter(topic, key, marshalled, lastErr)
+	}
+	return lastErr
+}
+
+// sendAndWait publishes a single record and blocks until drainProducerResults resolves its correlation id, or
+// ctx is done.
+func (sc *SaramaClient) sendAndWait(ctx context.Context, topic *Topic, key string, value []byte, contentType string, extraHeaders ...sarama.RecordHeader) error {
+	correlationID := uuid.New().String()
+	result := make(chan error, 1)
+
+	sc.lockProducerDeliveryMap.Lock()
+	sc.producerDeliveryMap[correlationID] = result
+	sc.lockProducerDeliveryMap.Unlock()
+	defer func() {
+		sc.lockProducerDeliveryMap.Lock()
+		delete(sc.producerDeliveryMap, correlationID)
+		sc.lockProducerDeliveryMap.Unlock()
+	}()
+
+	headers := append([]sarama.RecordHeader{{Key: []byte(ContentTypeHeader), Value: []byte(contentType)}}, extraHeaders...)
+	msg := &sarama.ProducerMessage{
+		Topic:    topic.Name,
+		Key:      sarama.StringEncoder(key),
+		Value:    sarama.ByteEncoder(value),
+		Headers:  headers,
+		Metadata: correlationID,
+	}
+
+	// Respect ctx on the send itself, not just the reply: if the producer's internal input buffer is full (e.g.
+	// the broker is down and retries are backed up), an unselected send here would block past ctx's deadline
+	// despite the select below correctly bounding the wait for a reply.
+	select {
+	case sc.producer.Input() <- msg:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	select {
+	case err := <-result:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// sendToDeadLetter republishes a permanently-failed message to sc.deadLetterTopic, tagged with why the original
+// send never succeeded. Failure to do even that is logged rather than returned, since the caller already has the
+// original error to act on.
+func (sc *SaramaClient) sendToDeadLetter(topic *Topic, key string, value []byte, reason error) {
+	reasonText := ""
+	if reason != nil {
+		reasonText = reason.Error()
+	}
+	extraHeaders := []sarama.RecordHeader{
+		{Key: []byte(DeadLetterOriginalTopicHeader), Value: []byte(topic.Name)},
+		{Key: []byte(DeadLetterReasonHeader), Value: []byte(reasonText)},
+	}
+	if err := sc.sendAndWait(context.Background(), sc.deadLetterTopic, key, value, sc.codec.ContentType(), extraHeaders...); err != nil {
+		log.Errorw("dead-letter-publish-failed", log.Fields{"topic": topic.Name, "dead-letter-topic": sc.deadLetterTopic.Name, "error": err})
+	}
+}
+
+// drainProducerResults continuously drains the producer's Successes()/Errors() channels for the life of the
+// client. SendSync's sendAndWait correlates a reply off these channels by the ProducerMessage.Metadata it set;
+// a Send call that never asked for a reply is simply logged on failure, since nothing is waiting on it.
+func (sc *SaramaClient) drainProducerResults() {
+	for {
+		select {
+		case success, ok := <-sc.producer.Successes():
+			if !ok {
+				return
+			}
+			sc.resolveDelivery(success.Metadata, nil)
+		case prodErr, ok := <-sc.producer.Errors():
+			if !ok {
+				return
+			}
+			var metadata interface{}
+			if prodErr.Msg != nil {
+				metadata = prodErr.Msg.Metadata
+			}
+			sc.resolveDelivery(metadata, prodErr.Err)
+		case <-sc.doneCh:
+			return
+		}
+	}
+}
+
+// resolveDelivery hands a producer result to whichever sendAndWait call is waiting on its correlation id, if any.
+func (sc *SaramaClient) resolveDelivery(metadata interface{}, err error) {
+	if err != nil {
+		sc.instrumentation.sendErrors.Inc()
+	} else {
+		sc.instrumentation.messagesSent.Inc()
+	}
+
+	correlationID, ok := metadata.(string)
+	if !ok || correlationID == "" {
+		if err != nil {
+			log.Warnw("async-send-failed", log.Fields{"error": err})
+		}
+		return
+	}
+
+	sc.lockProducerDeliveryMap.Lock()
+	result, have := sc.producerDeliveryMap[correlationID]
+	sc.lockProducerDeliveryMap.Unlock()
+	if have {
+		result <- err
+	}
+}
+
+// brokerList returns the bootstrap broker addresses to dial: the explicit list passed via the Brokers option if
+// one was given, otherwise the single KafkaHost:KafkaPort address kept for backward compatibility.
+func (sc *SaramaClient) brokerList() []string {
+	if len(sc.brokers) > 0 {
+		return sc.brokers
+	}
+	return []string{fmt.Sprintf("%s:%d", sc.KafkaHost, sc.KafkaPort)}
+}
+
+// configureSecurity applies the TLS and SASL options given to NewSaramaClient, if any, to config.
+func (sc *SaramaClient) configureSecurity(config *sarama.Config) error {
+	if sc.tlsConfig != nil {
+		applyTLS(config, sc.tlsConfig)
+	}
+	if sc.saslMechanism != "" {
+		if err := applySASL(config, sc.saslMechanism, sc.saslUser, sc.saslPassword); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (sc *SaramaClient) createClusterAdmin() error {
-	kafkaFullAddr := fmt.Sprintf("%s:%d", sc.KafkaHost, sc.KafkaPort)
 	config := sarama.NewConfig()
 	config.Version = sarama.V1_0_0_0
+	if err := sc.configureSecurity(config); err != nil {
+		return err
+	}
 
 	// Create a cluster Admin
 	var cAdmin sarama.ClusterAdmin
 	var err error
-	if cAdmin, err = sarama.NewClusterAdmin([]string{kafkaFullAddr}, config); err != nil {
-		log.Errorw("cluster-admin-failure", log.Fields{"error": err, "broker-address": kafkaFullAddr})
+	if cAdmin, err = sarama.NewClusterAdmin(sc.brokerList(), config); err != nil {
+		log.Errorw("cluster-admin-failure", log.Fields{"error": err, "broker-address": sc.brokerList()})
 		return err
 	}
 	sc.cAdmin = cAdmin
 	return nil
 }
 
+// createClient opens a sarama.Client against the broker, independent of the cluster admin and producer/consumer
+// connections, so reportConsumerLag can look up a partition's newest offset without disturbing them.
+func (sc *SaramaClient) createClient() error {
+	config := sarama.NewConfig()
+	config.Version = sarama.V1_0_0_0
+	if err := sc.configureSecurity(config); err != nil {
+		return err
+	}
+
+	client, err := sarama.NewClient(sc.brokerList(), config)
+	if err != nil {
+		log.Errorw("kafka-client-failure", log.Fields{"error": err, "broker-address": sc.brokerList()})
+		return err
+	}
+	sc.client = client
+	return nil
+}
+
 func (sc *SaramaClient) addTopicToConsumerChannelMap(id string, arg *consumerChannels) {
 	sc.lockTopicToConsumerChannelMap.Lock()
 	defer sc.lockTopicToConsumerChannelMap.Unlock()
@@ -411,24 +798,24 @@ func (sc *SaramaClient) deleteFromTopicToConsumerChannelMap(id string) {
 	}
 }
 
-func (sc *SaramaClient) getConsumerChannel(topic *Topic) *consumerChannels {
+func (sc *SaramaClient) getConsumerChannel(key string) *consumerChannels {
 	sc.lockTopicToConsumerChannelMap.Lock()
 	defer sc.lockTopicToConsumerChannelMap.Unlock()
 
-	if consumerCh, exist := sc.topicToConsumerChannelMap[topic.Name]; exist {
+	if consumerCh, exist := sc.topicToConsumerChannelMap[key]; exist {
 		return consumerCh
 	}
 	return nil
 }
 
-func (sc *SaramaClient) addChannelToConsumerChannelMap(topic *Topic, ch chan *ca.InterContainerMessage) {
+func (sc *SaramaClient) addChannelToConsumerChannelMap(key string, sub *subscriberChannel) {
 	sc.lockTopicToConsumerChannelMap.Lock()
 	defer sc.lockTopicToConsumerChannelMap.Unlock()
-	if consumerCh, exist := sc.topicToConsumerChannelMap[topic.Name]; exist {
-		consumerCh.channels = append(consumerCh.channels, ch)
+	if consumerCh, exist := sc.topicToConsumerChannelMap[key]; exist {
+		consumerCh.channels = append(consumerCh.channels, sub)
 		return
 	}
-	log.Warnw("consumers-channel-not-exist", log.Fields{"topic": topic.Name})
+	log.Warnw("consumers-channel-not-exist", log.Fields{"key": key})
 }
 
 //closeConsumers closes a list of sarama consumers.  The consumers can either be a partition consumers or a group consumers
@@ -446,7 +833,7 @@ func closeConsumers(consumers []interface{}) error {
 					err = errTemp
 				}
 			}
-		} else if groupConsumer, ok := consumer.(*scc.Consumer); ok {
+		} else if groupConsumer, ok := consumer.(sarama.ConsumerGroup); ok {
 			if errTemp := groupConsumer.Close(); errTemp != nil {
 				if strings.Compare(errTemp.Error(), sarama.ErrUnknownTopicOrPartition.Error()) == 0 {
 					// This can occur on race condition
@@ -460,45 +847,64 @@ func closeConsumers(consumers []interface{}) error {
 	return err
 }
 
-func (sc *SaramaClient) removeChannelFromConsumerChannelMap(topic Topic, ch <-chan *ca.InterContainerMessage) error {
+// closeOffsetManager closes the sarama.OffsetManager a PartitionConsumer mode subscription created, if any, once
+// its consumerChannels is being torn down for good. A no-op for a GroupCustomer subscription, which never sets
+// one.
+func closeOffsetManager(consumerCh *consumerChannels) {
+	if consumerCh.offsetManager == nil {
+		return
+	}
+	if err := consumerCh.offsetManager.Close(); err != nil {
+		log.Warnw("close-offset-manager-failed", log.Fields{"topic": consumerCh.topicName, "error": err})
+	}
+}
+
+func (sc *SaramaClient) removeChannelFromConsumerChannelMap(key string, ch <-chan *Envelope) error {
 	sc.lockTopicToConsumerChannelMap.Lock()
 	defer sc.lockTopicToConsumerChannelMap.Unlock()
-	if consumerCh, exist := sc.topicToConsumerChannelMap[topic.Name]; exist {
+	if consumerCh, exist := sc.topicToConsumerChannelMap[key]; exist {
 		// Channel will be closed in the removeChannel method
 		consumerCh.channels = removeChannel(consumerCh.channels, ch)
 		// If there are no more channels then we can close the consumers itself
 		if len(consumerCh.channels) == 0 {
-			log.Debugw("closing-consumers", log.Fields{"topic": topic})
+			log.Debugw("closing-consumers", log.Fields{"key": key})
 			err := closeConsumers(consumerCh.consumers)
+			closeOffsetManager(consumerCh)
 			//err := consumerCh.consumers.Close()
-			delete(sc.topicToConsumerChannelMap, topic.Name)
+			delete(sc.topicToConsumerChannelMap, key)
 			return err
 		}
 		return nil
 	}
-	log.Warnw("topic-does-not-exist", log.Fields{"topic": topic.Name})
+	log.Warnw("topic-does-not-exist", log.Fields{"key": key})
 	return errors.New("topic-does-not-exist")
 }
 
+// clearTopicFromConsumerChannelMap tears down every subscription on the given topic, across every group id it
+// may have been subscribed under.
 func (sc *SaramaClient) clearTopicFromConsumerChannelMap(topic Topic) error {
 	sc.lockTopicToConsumerChannelMap.Lock()
 	defer sc.lockTopicToConsumerChannelMap.Unlock()
-	if consumerCh, exist := sc.topicToConsumerChannelMap[topic.Name]; exist {
-		for _, ch := range consumerCh.channels {
-			// Channel will be closed in the removeChannel method
-			removeChannel(consumerCh.channels, ch)
-		}
-		err := closeConsumers(consumerCh.consumers)
-		//if err == sarama.ErrUnknownTopicOrPartition {
-		//	// Not an error
-		//	err = nil
-		//}
-		//err := consumerCh.consumers.Close()
-		delete(sc.topicToConsumerChannelMap, topic.Name)
-		return err
+	var err error
+	found := false
+	for key, consumerCh := range sc.topicToConsumerChannelMap {
+		if consumerCh.topicName != topic.Name {
+			continue
+		}
+		found = true
+		for _, sub := range consumerCh.channels {
+			sub.close()
+		}
+		if errTemp := closeConsumers(consumerCh.consumers); errTemp != nil {
+			err = errTemp
+		}
+		closeOffsetManager(consumerCh)
+		delete(sc.topicToConsumerChannelMap, key)
 	}
-	log.Debugw("topic-does-not-exist", log.Fields{"topic": topic.Name})
-	return nil
+	if !found {
+		log.Debugw("topic-does-not-exist", log.Fields{"topic": topic.Name})
+	}
+	return err
 }
 
 func (sc *SaramaClient) clearConsumerChannelMap() error {
@@ -506,13 +912,13 @@ func (sc *SaramaClient) clearConsumerChannelMap() error {
 	defer sc.lockTopicToConsumerChannelMap.Unlock()
 	var err error
 	for topic, consumerCh := range sc.topicToConsumerChannelMap {
-		for _, ch := range consumerCh.channels {
-			// Channel will be closed in the removeChannel method
-			removeChannel(consumerCh.channels, ch)
+		for _, sub := range consumerCh.channels {
+			sub.close()
 		}
 		if errTemp := closeConsumers(consumerCh.consumers); errTemp != nil {
 			err = errTemp
 		}
+		closeOffsetManager(consumerCh)
 		//err = consumerCh.consumers.Close()
 		delete(sc.topicToConsumerChannelMap, topic)
 	}
@@ -527,15 +933,18 @@ func (sc *SaramaClient) createPublisher() error {
 	config.Producer.Flush.Frequency = time.Duration(sc.producerFlushFrequency)
 	config.Producer.Flush.Messages = sc.producerFlushMessages
 	config.Producer.Flush.MaxMessages = sc.producerFlushMaxmessages
-	config.Producer.Return.Errors = sc.producerReturnErrors
-	config.Producer.Return.Successes = sc.producerReturnSuccess
+	// Always returned, regardless of producerReturnErrors/producerReturnSuccess: drainProducerResults relies on
+	// both channels to correlate SendSync replies and must drain them anyway to keep the producer from blocking.
+	config.Producer.Return.Errors = true
+	config.Producer.Return.Successes = true
 	//config.Producer.RequiredAcks = sarama.WaitForAll
 	config.Producer.RequiredAcks = sarama.WaitForLocal
 
-	kafkaFullAddr := fmt.Sprintf("%s:%d", sc.KafkaHost, sc.KafkaPort)
-	brokers := []string{kafkaFullAddr}
+	if err := sc.configureSecurity(config); err != nil {
+		return err
+	}
 
-	if producer, err := sarama.NewAsyncProducer(brokers, config); err != nil {
+	if producer, err := sarama.NewAsyncProducer(sc.brokerList(), config); err != nil {
 		log.Errorw("error-starting-publisher", log.Fields{"error": err})
 		return err
 	} else {
@@ -552,10 +961,12 @@ func (sc *SaramaClient) createConsumer() error {
 	config.Consumer.MaxWaitTime = time.Duration(sc.consumerMaxwait) * time.Millisecond
 	config.Consumer.MaxProcessingTime = time.Duration(sc.maxProcessingTime) * time.Millisecond
 	config.Consumer.Offsets.Initial = sarama.OffsetNewest
-	kafkaFullAddr := fmt.Sprintf("%s:%d", sc.KafkaHost, sc.KafkaPort)
-	brokers := []string{kafkaFullAddr}
 
-	if consumer, err := sarama.NewConsumer(brokers, config); err != nil {
+	if err := sc.configureSecurity(config); err != nil {
+		return err
+	}
+
+	if consumer, err := sarama.NewConsumer(sc.brokerList(), config); err != nil {
 		log.Errorw("error-starting-consumers", log.Fields{"error": err})
 		return err
 	} else {
@@ -565,137 +976,351 @@ func (sc *SaramaClient) createConsumer() error {
 	return nil
 }
 
-// createGroupConsumer creates a consumers group
-func (sc *SaramaClient) createGroupConsumer(topic *Topic, groupId *string, retries int) (*scc.Consumer, error) {
-	config := scc.NewConfig()
+// groupRebalanceStrategy maps the string strategy name carried on SubscribeOptions to sarama's built-in
+// BalanceStrategy, falling back to range (sarama's own default) for an empty or unrecognized name.
+func groupRebalanceStrategy(name string) sarama.BalanceStrategy {
+	switch name {
+	case "roundrobin":
+		return sarama.BalanceStrategyRoundRobin
+	case "sticky":
+		return sarama.BalanceStrategySticky
+	default:
+		return sarama.BalanceStrategyRange
+	}
+}
+
+// createGroupConsumer creates a sarama.ConsumerGroup, honouring the session timeout, rebalance strategy, and
+// initial offset carried on opts instead of always falling back to the client-wide defaults.
+func (sc *SaramaClient) createGroupConsumer(topic *Topic, opts SubscribeOptions, retries int) (sarama.ConsumerGroup, error) {
+	config := sarama.NewConfig()
+	config.Version = sarama.V1_0_0_0
 	config.ClientID = uuid.New().String()
-	config.Group.Mode = scc.ConsumerModeMultiplex
-	//config.Consumer.Return.Errors = true
-	//config.Group.Return.Notifications = false
-	//config.Consumer.MaxWaitTime = time.Duration(DefaultConsumerMaxwait) * time.Millisecond
-	//config.Consumer.MaxProcessingTime = time.Duration(DefaultMaxProcessingTime) * time.Millisecond
-	config.Consumer.Offsets.Initial = sarama.OffsetNewest
-	kafkaFullAddr := fmt.Sprintf("%s:%d", sc.KafkaHost, sc.KafkaPort)
-	brokers := []string{kafkaFullAddr}
+	config.Consumer.Return.Errors = true
+	if opts.SessionTimeout > 0 {
+		config.Consumer.Group.Session.Timeout = opts.SessionTimeout
+	}
+	config.Consumer.Offsets.Initial = opts.InitialOffset
+	config.Consumer.Group.Rebalance.Strategy = groupRebalanceStrategy(opts.RebalanceStrategy)
+	if err := sc.configureSecurity(config); err != nil {
+		return nil, err
+	}
 
-	if groupId == nil {
-		g := DefaultGroupName
-		groupId = &g
+	groupId := opts.GroupId
+	if groupId == "" {
+		groupId = DefaultGroupName
 	}
-	topics := []string{topic.Name}
-	var consumer *scc.Consumer
-	var err error
 
-	if consumer, err = scc.NewConsumer(brokers, *groupId, topics, config); err != nil {
+	group, err := sarama.NewConsumerGroup(sc.brokerList(), groupId, config)
+	if err != nil {
 		log.Errorw("create-consumers-failure", log.Fields{"error": err, "topic": topic.Name, "groupId": groupId})
 		return nil, err
 	}
 	log.Debugw("create-consumers-success", log.Fields{"topic": topic.Name, "groupId": groupId})
-	//time.Sleep(10*time.Second)
-	sc.groupConsumer = consumer
-	return consumer, nil
+
+	sc.lockTopicToConsumerChannelMap.Lock()
+	sc.groupConsumers[consumerChannelKey(topic, opts)] = group
+	sc.lockTopicToConsumerChannelMap.Unlock()
+	return group, nil
 }
 
 // dispatchToConsumers sends the intercontainermessage received on a given topic to all subscribers for that
-// topic via the unique channel each subsciber received during subscription
-func (sc *SaramaClient) dispatchToConsumers(consumerCh *consumerChannels, protoMessage *ca.InterContainerMessage) {
-	// Need to go over all channels and publish messages to them - do we need to copy msg?
+// topic via the unique channel each subscriber received during subscription. The lock is only held long enough
+// to snapshot the subscriber list: a subscriber applying its DispatchPolicy, including a Block subscriber that
+// is genuinely slow, can no longer serialize dispatch to every other subscriber of the topic.
+func (sc *SaramaClient) dispatchToConsumers(consumerCh *consumerChannels, envelope *Envelope) {
+	start := time.Now()
+
+	sc.lockTopicToConsumerChannelMap.Lock()
+	subs := make([]*subscriberChannel, len(consumerCh.channels))
+	copy(subs, consumerCh.channels)
+	sc.lockTopicToConsumerChannelMap.Unlock()
+
+	var wg sync.WaitGroup
+	wg.Add(len(subs))
+	for _, sub := range subs {
+		go func(s *subscriberChannel) {
+			defer wg.Done()
+			if !s.dispatch(envelope) {
+				log.Warnw("dispatch-policy-disconnecting-slow-subscriber", log.Fields{"topic": consumerCh.topicName, "policy": s.policy.String()})
+				sc.instrumentation.subscriberDisconnects.WithLabelValues(consumerCh.topicName, "full").Inc()
+				sc.disconnectSubscriber(consumerCh, s)
+				return
+			}
+			sc.instrumentation.subscriberDepth.WithLabelValues(consumerCh.topicName).Set(float64(len(s.ch)))
+		}(sub)
+	}
+	wg.Wait()
+
+	sc.instrumentation.dispatchLatency.Observe(time.Since(start).Seconds())
+}
+
+// reportConsumerLag sets the consumer_lag gauge for topic/partition to the gap between the partition's newest
+// offset and the offset just consumed. It logs and returns without updating the gauge if the client cannot reach
+// the broker, since a stale lag reading is worse than a missing one only if it were reported as zero.
+func (sc *SaramaClient) reportConsumerLag(topic *Topic, partition int32, offset int64) {
+	if sc.client == nil {
+		return
+	}
+	newest, err := sc.client.GetOffset(topic.Name, partition, sarama.OffsetNewest)
+	if err != nil {
+		log.Warnw("consumer-lag-offset-lookup-failed", log.Fields{"topic": topic.Name, "partition": partition, "error": err})
+		return
+	}
+	lag := newest - offset - 1
+	if lag < 0 {
+		lag = 0
+	}
+	sc.instrumentation.consumerLag.WithLabelValues(topic.Name, strconv.Itoa(int(partition))).Set(float64(lag))
+}
+
+// disconnectSubscriber removes a DisconnectSlow subscriber that fell behind from the topic's subscriber list and
+// closes its channel, so the caller sees a closed channel rather than silently stalling forever. It closes sub
+// through subscriberChannel.close rather than closing sub.ch directly, so a dispatch(envelope) call that is
+// concurrently blocked sending to sub.ch (a Block subscriber watchSubscriber is disconnecting out from under
+// itself) wakes up via its stop case instead of racing the channel close - sending on a channel a blocked sender
+// is still waiting on panics, whereas selecting on it closed does not.
+func (sc *SaramaClient) disconnectSubscriber(consumerCh *consumerChannels, sub *subscriberChannel) {
 	sc.lockTopicToConsumerChannelMap.Lock()
 	defer sc.lockTopicToConsumerChannelMap.Unlock()
-	for _, ch := range consumerCh.channels {
-		go func(c chan *ca.InterContainerMessage) {
-			c <- protoMessage
-		}(ch)
+	for i, s := range consumerCh.channels {
+		if s == sub {
+			consumerCh.channels[len(consumerCh.channels)-1], consumerCh.channels[i] = consumerCh.channels[i], consumerCh.channels[len(consumerCh.channels)-1]
+			consumerCh.channels = consumerCh.channels[:len(consumerCh.channels)-1]
+			sub.close()
+			return
+		}
+	}
+}
+
+// watchdogPollInterval is how often watchSubscriber checks whether a subscriber's channel has stalled.
+const watchdogPollInterval = 1 * time.Second
+
+// watchSubscriber disconnects sub, the same way a DisconnectSlow delivery failure would, if its channel sits
+// completely full for longer than timeout. A Block subscriber never drops a message or disconnects on its own
+// when its reader stops entirely, so without this one wedged subscriber pins a goroutine per dispatched message
+// in dispatchToConsumers forever - this runs for every subscriber by default, via DefaultLivenessTimeout, so that
+// leak can no longer happen unless a caller explicitly opts out with WithLivenessTimeout(0). It re-resolves the
+// subscription's consumerChannels from key on every check, since by the time it fires the topic may already have
+// been torn down by UnSubscribe or DeleteTopic. Disabled when timeout is zero.
+func (sc *SaramaClient) watchSubscriber(key string, sub *subscriberChannel, timeout time.Duration) {
+	if timeout <= 0 {
+		return
+	}
+	ticker := time.NewTicker(watchdogPollInterval)
+	defer ticker.Stop()
+
+	var fullSince time.Time
+	for {
+		select {
+		case <-ticker.C:
+			if len(sub.ch) < cap(sub.ch) {
+				fullSince = time.Time{}
+				continue
+			}
+			if fullSince.IsZero() {
+				fullSince = time.Now()
+				continue
+			}
+			if time.Since(fullSince) < timeout {
+				continue
+			}
+			consumerCh := sc.getConsumerChannel(key)
+			if consumerCh == nil {
+				return
+			}
+			log.Warnw("subscriber-liveness-timeout-disconnecting", log.Fields{"topic": consumerCh.topicName, "timeout": timeout})
+			sc.instrumentation.subscriberDisconnects.WithLabelValues(consumerCh.topicName, "stalled").Inc()
+			sc.disconnectSubscriber(consumerCh, sub)
+			return
+		case <-sc.doneCh:
+			return
+		}
 	}
 }
 
-func (sc *SaramaClient) consumeFromAPartition(topic *Topic, consumer sarama.PartitionConsumer, consumerChnls *consumerChannels) {
-	log.Debugw("starting-partition-consumption-loop", log.Fields{"topic": topic.Name})
+// consumeFromAPartition drives consumer for partition of topic until it is closed - by sc.Stop(), by
+// UnSubscribe/DeleteTopic tearing down the whole subscription, or by this loop itself retiring the partition
+// below. pom, if non-nil, is this partition's PartitionOffsetManager: every delivered message's offset is
+// committed through it so a partition recreated later by reconcilePartitions or after ErrOffsetOutOfRange
+// resumes from the last offset processed instead of restarting at opts.InitialOffset.
+func (sc *SaramaClient) consumeFromAPartition(topic *Topic, partition int32, consumer sarama.PartitionConsumer, pom sarama.PartitionOffsetManager, consumerChnls *consumerChannels) {
+	log.Debugw("starting-partition-consumption-loop", log.Fields{"topic": topic.Name, "partition": partition})
+	if pom != nil {
+		defer pom.AsyncClose()
+	}
+
+	offsetOutOfRange := false
 startloop:
 	for {
 		select {
 		case err := <-consumer.Errors():
-			if err != nil {
-				log.Warnw("partition-consumers-error", log.Fields{"error": err})
-			} else {
+			if err == nil {
 				// There is a race condition when this loop is stopped and the consumer is closed where
 				// the actual error comes as nil
 				log.Warn("partition-consumers-error")
+				continue
+			}
+			log.Warnw("partition-consumers-error", log.Fields{"error": err})
+			if err.Err == sarama.ErrOffsetOutOfRange {
+				// Nothing this loop does can recover from this; retire the partition below so the next
+				// reconcilePartitions pass recreates it from the offset pom last committed.
+				offsetOutOfRange = true
+				break startloop
 			}
 		case msg := <-consumer.Messages():
 			//log.Debugw("message-received", log.Fields{"msg": msg, "receivedTopic": msg.Topic})
 			if msg == nil {
 				// There is a race condition when this loop is stopped and the consumer is closed where
-				// the actual msg comes as nil
+				// the actual msg comes as nil. This is also how a partition reconcilePartitions decided to
+				// retire surfaces here, once its Close() drains these channels.
 				break startloop
 			}
-			msgBody := msg.Value
-			icm := &ca.InterContainerMessage{}
-			if err := proto.Unmarshal(msgBody, icm); err != nil {
+			codec := sc.codecByContentType(contentTypeFromHeaders(msg.Headers))
+			decoded := sc.messageFactory(topic.Name)()
+			if err := codec.Unmarshal(msg.Value, decoded); err != nil {
+				sc.instrumentation.unmarshalErrors.Inc()
 				log.Warnw("partition-invalid-message", log.Fields{"error": err})
+				// Commit past it anyway: a message that fails to decode will never decode on redelivery either,
+				// so leaving its offset uncommitted would wedge this partition on it permanently, re-fetching and
+				// re-failing to unmarshal the same record every time the partition consumer is recreated.
+				if pom != nil {
+					pom.MarkOffset(msg.Offset+1, "")
+				}
 				continue
 			}
-			go sc.dispatchToConsumers(consumerChnls, icm)
+			sc.instrumentation.messagesReceived.Inc()
+			sc.reportConsumerLag(topic, msg.Partition, msg.Offset)
+			go sc.dispatchToConsumers(consumerChnls, &Envelope{Message: decoded, Codec: codec})
+			if pom != nil {
+				pom.MarkOffset(msg.Offset+1, "")
+			}
 		case <-sc.doneCh:
-			log.Infow("partition-received-exit-signal", log.Fields{"topic": topic.Name})
-			break startloop
+			log.Infow("partition-received-exit-signal", log.Fields{"topic": topic.Name, "partition": partition})
+			return
 		}
 	}
-	log.Infow("partition-consumer-stopped", log.Fields{"topic": topic.Name})
+
+	if offsetOutOfRange {
+		_ = consumer.Close()
+		sc.removePartitionConsumer(consumerChnls, partition)
+	}
+	log.Infow("partition-consumer-stopped", log.Fields{"topic": topic.Name, "partition": partition})
 }
 
-func (sc *SaramaClient) consumeGroupMessages(topic *Topic, consumer *scc.Consumer, consumerChnls *consumerChannels) {
-	log.Debugw("starting-group-consumption-loop", log.Fields{"topic": topic.Name})
+// groupConsumerHandler adapts a GroupCustomer subscription's consumerChannels to sarama's ConsumerGroupHandler
+// interface, fanning every message delivered to a claim into dispatchToConsumers and invoking onRebalance (if
+// set) as partitions are assigned on Setup and revoked on Cleanup.
+type groupConsumerHandler struct {
+	sc            *SaramaClient
+	topic         *Topic
+	consumerChnls *consumerChannels
+	onRebalance   RebalanceCallback
+}
 
-startloop:
+func (h *groupConsumerHandler) Setup(session sarama.ConsumerGroupSession) error {
+	log.Debugw("group-partitions-assigned", log.Fields{"topic": h.topic.Name, "claims": session.Claims()})
+	if h.onRebalance != nil {
+		h.onRebalance(PartitionsAssigned, session.Claims())
+	}
+	return nil
+}
+
+func (h *groupConsumerHandler) Cleanup(session sarama.ConsumerGroupSession) error {
+	log.Debugw("group-partitions-revoked", log.Fields{"topic": h.topic.Name, "claims": session.Claims()})
+	if h.onRebalance != nil {
+		h.onRebalance(PartitionsRevoked, session.Claims())
+	}
+	return nil
+}
+
+func (h *groupConsumerHandler) ConsumeClaim(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
 	for {
 		select {
-		case err := <-consumer.Errors():
-			if err != nil {
-				log.Warnw("group-consumers-error", log.Fields{"error": err})
-			} else {
-				// There is a race condition when this loop is stopped and the consumer is closed where
-				// the actual error comes as nil
-				log.Warn("group-consumers-error")
-			}
-		case msg := <-consumer.Messages():
-			//log.Debugw("message-received", log.Fields{"msg": msg, "receivedTopic": msg.Topic})
-			if msg == nil {
-				// There is a race condition when this loop is stopped and the consumer is closed where
-				// the actual msg comes as nil
-				break startloop
+		case msg, ok := <-claim.Messages():
+			if !ok {
+				// The claim's Messages() channel is closed once the broker revokes this partition.
+				return nil
 			}
-			msgBody := msg.Value
-			icm := &ca.InterContainerMessage{}
-			if err := proto.Unmarshal(msgBody, icm); err != nil {
+			codec := h.sc.codecByContentType(contentTypeFromHeaders(msg.Headers))
+			decoded := h.sc.messageFactory(h.topic.Name)()
+			if err := codec.Unmarshal(msg.Value, decoded); err != nil {
+				h.sc.instrumentation.unmarshalErrors.Inc()
 				log.Warnw("invalid-message", log.Fields{"error": err})
+				// Mark it anyway: a message that fails to decode will never decode on redelivery either, so
+				// leaving it unmarked would wedge this partition's committed offset on it permanently, re-fetching
+				// and re-failing to unmarshal the same record on every rebalance or restart.
+				session.MarkMessage(msg, "")
 				continue
 			}
-			go sc.dispatchToConsumers(consumerChnls, icm)
-			consumer.MarkOffset(msg, "")
-		case ntf := <-consumer.Notifications():
-			log.Debugw("group-received-notification", log.Fields{"notification": ntf})
-		case <-sc.doneCh:
-			log.Infow("group-received-exit-signal", log.Fields{"topic": topic.Name})
-			break startloop
+			h.sc.instrumentation.messagesReceived.Inc()
+			h.sc.reportConsumerLag(h.topic, msg.Partition, msg.Offset)
+			go h.sc.dispatchToConsumers(h.consumerChnls, &Envelope{Message: decoded, Codec: codec})
+			session.MarkMessage(msg, "")
+		case <-session.Context().Done():
+			return nil
 		}
 	}
+}
+
+// consumeGroupMessages drives group on topic for the lifetime of the client. ConsumerGroup.Consume returns
+// every time the group's generation ends - a rebalance, a lost session, or ctx being cancelled - and must be
+// re-invoked to keep consuming, which is what the cooperative rebalancing contract expects of callers. A
+// consecutive run of session errors is backed off exponentially so a broker outage does not spin the retry loop.
+func (sc *SaramaClient) consumeGroupMessages(topic *Topic, group sarama.ConsumerGroup, consumerChnls *consumerChannels, opts SubscribeOptions) {
+	log.Debugw("starting-group-consumption-loop", log.Fields{"topic": topic.Name})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-sc.doneCh
+		cancel()
+	}()
+
+	go func() {
+		for err := range group.Errors() {
+			log.Warnw("group-consumers-error", log.Fields{"topic": topic.Name, "error": err})
+		}
+	}()
+
+	handler := &groupConsumerHandler{sc: sc, topic: topic, consumerChnls: consumerChnls, onRebalance: opts.RebalanceCallback}
+
+	backoff := defaultGroupConsumeRetryBackoff
+	for ctx.Err() == nil {
+		if err := group.Consume(ctx, []string{topic.Name}, handler); err != nil {
+			if ctx.Err() != nil {
+				break
+			}
+			log.Warnw("group-consume-session-ended", log.Fields{"topic": topic.Name, "error": err})
+			select {
+			case <-time.After(backoff):
+				backoff *= 2
+				if backoff > maxGroupConsumeRetryBackoff {
+					backoff = maxGroupConsumeRetryBackoff
+				}
+			case <-ctx.Done():
+			}
+			continue
+		}
+		backoff = defaultGroupConsumeRetryBackoff
+	}
 	log.Infow("group-consumer-stopped", log.Fields{"topic": topic.Name})
 }
 
-func (sc *SaramaClient) startConsumers(topic *Topic) error {
+// startConsumers launches the consumption loop for consumerCh's GroupCustomer consumer. PartitionConsumer mode
+// does not use this path: startPartitionConsumer starts each of its partitions individually, both the initial
+// set and any picked up later by reconcilePartitions, so a single code path covers both cases.
+func (sc *SaramaClient) startConsumers(topic *Topic, key string, opts SubscribeOptions) error {
 	log.Debugw("starting-consumers", log.Fields{"topic": topic.Name})
 	var consumerCh *consumerChannels
-	if consumerCh = sc.getConsumerChannel(topic); consumerCh == nil {
+	if consumerCh = sc.getConsumerChannel(key); consumerCh == nil {
 		log.Errorw("consumers-not-exist", log.Fields{"topic": topic.Name})
 		return errors.New("consumers-not-exist")
 	}
 	// For each consumer listening for that topic, start a consumption loop
 	for _, consumer := range consumerCh.consumers {
-		if pConsumer, ok := consumer.(sarama.PartitionConsumer); ok {
-			go sc.consumeFromAPartition(topic, pConsumer, consumerCh)
-		} else if gConsumer, ok := consumer.(*scc.Consumer); ok {
-			go sc.consumeGroupMessages(topic, gConsumer, consumerCh)
+		if _, ok := consumer.(sarama.PartitionConsumer); ok {
+			continue
+		} else if gConsumer, ok := consumer.(sarama.ConsumerGroup); ok {
+			go sc.consumeGroupMessages(topic, gConsumer, consumerCh, opts)
 		} else {
 			log.Errorw("invalid-consumer", log.Fields{"topic": topic})
 			return errors.New("invalid-consumer")
@@ -706,91 +1331,212 @@ func (sc *SaramaClient) startConsumers(topic *Topic) error {
 
 //// setupConsumerChannel creates a consumerChannels object for that topic and add it to the consumerChannels map
 //// for that topic.  It also starts the routine that listens for messages on that topic.
-func (sc *SaramaClient) setupPartitionConsumerChannel(topic *Topic, initialOffset int64) (chan *ca.InterContainerMessage, error) {
-	var pConsumers []sarama.PartitionConsumer
-	var err error
-
-	if pConsumers, err = sc.createPartionConsumers(topic, initialOffset); err != nil {
-		log.Errorw("creating-partition-consumers-failure", log.Fields{"error": err, "topic": topic.Name})
+func (sc *SaramaClient) setupPartitionConsumerChannel(topic *Topic, key string, opts SubscribeOptions) (chan *Envelope, error) {
+	groupId := opts.GroupId
+	if groupId == "" {
+		groupId = DefaultGroupName
+	}
+	offsetManager, err := sarama.NewOffsetManagerFromClient(groupId, sc.client)
+	if err != nil {
+		log.Errorw("create-offset-manager-failure", log.Fields{"error": err, "topic": topic.Name})
 		return nil, err
 	}
 
-	consumersIf := make([]interface{}, 0)
-	for _, pConsumer := range pConsumers {
-		consumersIf = append(consumersIf, pConsumer)
+	partitionList, err := sc.consumer.Partitions(topic.Name)
+	if err != nil {
+		log.Warnw("get-partition-failure", log.Fields{"error": err, "topic": topic.Name})
+		offsetManager.Close()
+		return nil, err
 	}
 
-	// Create the consumers/channel structure and set the consumers and create a channel on that topic - for now
-	// unbuffered to verify race conditions.
-	consumerListeningChannel := make(chan *ca.InterContainerMessage)
+	// Create the consumers/channel structure and a bounded channel on that topic, sized and policed per
+	// opts.MaxInFlight/opts.DispatchPolicy so a slow first subscriber cannot stall the partition consumption
+	// loop itself. consumers/partitionConsumers start empty: startPartitionConsumer below populates them, the
+	// same call reconcilePartitions makes later for a partition that appears or is recreated.
+	sub := newSubscriberChannel(opts.MaxInFlight, opts.DispatchPolicy)
 	cc := &consumerChannels{
-		consumers: consumersIf,
-		channels:  []chan *ca.InterContainerMessage{consumerListeningChannel},
+		topicName:          topic.Name,
+		channels:           []*subscriberChannel{sub},
+		partitionConsumers: make(map[int32]sarama.PartitionConsumer, len(partitionList)),
+		offsetManager:      offsetManager,
 	}
 
 	// Add the consumers channel to the map
-	sc.addTopicToConsumerChannelMap(topic.Name, cc)
+	sc.addTopicToConsumerChannelMap(key, cc)
 
-	//Start a consumers to listen on that specific topic
-	go sc.startConsumers(topic)
+	for _, partition := range partitionList {
+		if err := sc.startPartitionConsumer(topic, partition, cc, opts); err != nil {
+			log.Errorw("consumers-partition-failure", log.Fields{"error": err, "topic": topic.Name, "partition": partition})
+			return nil, err
+		}
+	}
 
-	return consumerListeningChannel, nil
+	go sc.watchSubscriber(key, sub, opts.LivenessTimeout)
+	go sc.reconcilePartitions(topic, key, opts)
+
+	return sub.ch, nil
 }
 
-// setupConsumerChannel creates a consumerChannels object for that topic and add it to the consumerChannels map
-// for that topic.  It also starts the routine that listens for messages on that topic.
-func (sc *SaramaClient) setupGroupConsumerChannel(topic *Topic, groupId string) (chan *ca.InterContainerMessage, error) {
-	// TODO:  Replace this development partition consumers with a group consumers
-	var pConsumer *scc.Consumer
-	var err error
-	if pConsumer, err = sc.createGroupConsumer(topic, &groupId, DefaultMaxRetries); err != nil {
+// setupGroupConsumerChannel creates a consumerChannels object for that topic/group and adds it to the consumerChannels
+// map.  It also starts the routine that listens for messages on that topic.
+func (sc *SaramaClient) setupGroupConsumerChannel(topic *Topic, key string, opts SubscribeOptions) (chan *Envelope, error) {
+	pConsumer, err := sc.createGroupConsumer(topic, opts, DefaultMaxRetries)
+	if err != nil {
 		log.Errorw("creating-partition-consumers-failure", log.Fields{"error": err, "topic": topic.Name})
 		return nil, err
 	}
-	// Create the consumers/channel structure and set the consumers and create a channel on that topic - for now
-	// unbuffered to verify race conditions.
-	consumerListeningChannel := make(chan *ca.InterContainerMessage)
+	// Create the consumers/channel structure and set the consumers and create a bounded channel on that topic,
+	// sized and policed per opts.MaxInFlight/opts.DispatchPolicy so a slow first subscriber cannot stall the
+	// group consumption loop itself.
+	sub := newSubscriberChannel(opts.MaxInFlight, opts.DispatchPolicy)
 	cc := &consumerChannels{
+		topicName: topic.Name,
 		consumers: []interface{}{pConsumer},
-		channels:  []chan *ca.InterContainerMessage{consumerListeningChannel},
+		channels:  []*subscriberChannel{sub},
 	}
 
 	// Add the consumers channel to the map
-	sc.addTopicToConsumerChannelMap(topic.Name, cc)
+	sc.addTopicToConsumerChannelMap(key, cc)
 
 	//Start a consumers to listen on that specific topic
-	go sc.startConsumers(topic)
+	go sc.startConsumers(topic, key, opts)
+	go sc.watchSubscriber(key, sub, opts.LivenessTimeout)
 
-	return consumerListeningChannel, nil
+	return sub.ch, nil
 }
 
-func (sc *SaramaClient) createPartionConsumers(topic *Topic, initialOffset int64) ([]sarama.PartitionConsumer, error) {
-	log.Debugw("creating-partition-consumers", log.Fields{"topic": topic.Name})
-	partitionList, err := sc.consumer.Partitions(topic.Name)
+// startPartitionConsumer creates a PartitionConsumer for partition on topic, resuming from the offset
+// consumerCh's offsetManager last committed for it (or opts.InitialOffset if none exists yet), registers it on
+// consumerCh, and launches its consumption loop. Used both for a PartitionConsumer mode subscription's initial
+// set of partitions and by reconcilePartitions to pick up a partition that appeared later or was retired after
+// ErrOffsetOutOfRange.
+func (sc *SaramaClient) startPartitionConsumer(topic *Topic, partition int32, consumerCh *consumerChannels, opts SubscribeOptions) error {
+	offset := opts.InitialOffset
+	var pom sarama.PartitionOffsetManager
+	if consumerCh.offsetManager != nil {
+		var err error
+		if pom, err = consumerCh.offsetManager.ManagePartition(topic.Name, partition); err != nil {
+			log.Warnw("partition-offset-manager-failure", log.Fields{"topic": topic.Name, "partition": partition, "error": err})
+		} else if committed, _ := pom.NextOffset(); committed >= 0 {
+			offset = committed
+		}
+	}
+
+	pConsumer, err := sc.consumer.ConsumePartition(topic.Name, partition, offset)
 	if err != nil {
-		log.Warnw("get-partition-failure", log.Fields{"error": err, "topic": topic.Name})
-		return nil, err
+		if pom != nil {
+			pom.AsyncClose()
+		}
+		log.Warnw("consumers-partition-failure", log.Fields{"error": err, "topic": topic.Name, "partition": partition})
+		return err
 	}
 
-	pConsumers := make([]sarama.PartitionConsumer, 0)
-	for _, partition := range partitionList {
-		var pConsumer sarama.PartitionConsumer
-		if pConsumer, err = sc.consumer.ConsumePartition(topic.Name, partition, initialOffset); err != nil {
-			log.Warnw("consumers-partition-failure", log.Fields{"error": err, "topic": topic.Name})
-			return nil, err
+	sc.lockTopicToConsumerChannelMap.Lock()
+	consumerCh.consumers = append(consumerCh.consumers, pConsumer)
+	consumerCh.partitionConsumers[partition] = pConsumer
+	sc.lockTopicToConsumerChannelMap.Unlock()
+
+	go sc.consumeFromAPartition(topic, partition, pConsumer, pom, consumerCh)
+	return nil
+}
+
+// removePartitionConsumer drops partition from consumerCh's partition map and consumer list, mirroring what
+// removeChannel does for a subscriber channel, once its PartitionConsumer has already been closed - either by
+// consumeFromAPartition retiring itself after ErrOffsetOutOfRange, or by reconcilePartitions retiring a
+// partition the broker no longer reports. Either way this just makes the partition a candidate for
+// reconcilePartitions to recreate on its next pass.
+func (sc *SaramaClient) removePartitionConsumer(consumerCh *consumerChannels, partition int32) {
+	sc.lockTopicToConsumerChannelMap.Lock()
+	defer sc.lockTopicToConsumerChannelMap.Unlock()
+	pConsumer, ok := consumerCh.partitionConsumers[partition]
+	if !ok {
+		return
+	}
+	delete(consumerCh.partitionConsumers, partition)
+	for i, c := range consumerCh.consumers {
+		if c == pConsumer {
+			consumerCh.consumers[len(consumerCh.consumers)-1], consumerCh.consumers[i] = consumerCh.consumers[i], consumerCh.consumers[len(consumerCh.consumers)-1]
+			consumerCh.consumers = consumerCh.consumers[:len(consumerCh.consumers)-1]
+			break
+		}
+	}
+}
+
+// reconcilePartitions periodically re-reads topic's partition list for the lifetime of a PartitionConsumer mode
+// subscription at opts.PartitionReconcileInterval, since otherwise only the partitions that existed at Subscribe
+// time are ever consumed. A partition that appears later (the topic was expanded) gets picked up via
+// startPartitionConsumer, resuming from its last committed offset if reconcilePartitions has seen it before; one
+// that disappears (a shrink, or a leader change sarama's client no longer reports against this topic) has its
+// PartitionConsumer closed and removed.
+func (sc *SaramaClient) reconcilePartitions(topic *Topic, key string, opts SubscribeOptions) {
+	interval := opts.PartitionReconcileInterval
+	if interval <= 0 {
+		interval = DefaultPartitionReconcileInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			consumerCh := sc.getConsumerChannel(key)
+			if consumerCh == nil {
+				// The subscription was torn down; nothing left to reconcile.
+				return
+			}
+			partitionList, err := sc.consumer.Partitions(topic.Name)
+			if err != nil {
+				log.Warnw("reconcile-partitions-lookup-failed", log.Fields{"topic": topic.Name, "error": err})
+				continue
+			}
+
+			current := make(map[int32]bool, len(partitionList))
+			for _, partition := range partitionList {
+				current[partition] = true
+				sc.lockTopicToConsumerChannelMap.Lock()
+				_, exists := consumerCh.partitionConsumers[partition]
+				sc.lockTopicToConsumerChannelMap.Unlock()
+				if exists {
+					continue
+				}
+				log.Infow("reconcile-partitions-new-partition", log.Fields{"topic": topic.Name, "partition": partition})
+				if err := sc.startPartitionConsumer(topic, partition, consumerCh, opts); err != nil {
+					log.Warnw("reconcile-partitions-start-failed", log.Fields{"topic": topic.Name, "partition": partition, "error": err})
+				}
+			}
+
+			sc.lockTopicToConsumerChannelMap.Lock()
+			var stale []int32
+			for partition := range consumerCh.partitionConsumers {
+				if !current[partition] {
+					stale = append(stale, partition)
+				}
+			}
+			sc.lockTopicToConsumerChannelMap.Unlock()
+
+			for _, partition := range stale {
+				log.Infow("reconcile-partitions-removed-partition", log.Fields{"topic": topic.Name, "partition": partition})
+				sc.lockTopicToConsumerChannelMap.Lock()
+				pConsumer := consumerCh.partitionConsumers[partition]
+				sc.lockTopicToConsumerChannelMap.Unlock()
+				if pConsumer != nil {
+					_ = pConsumer.Close()
+				}
+				sc.removePartitionConsumer(consumerCh, partition)
+			}
+		case <-sc.doneCh:
+			return
 		}
-		pConsumers = append(pConsumers, pConsumer)
 	}
-	return pConsumers, nil
 }
 
-func removeChannel(channels []chan *ca.InterContainerMessage, ch <-chan *ca.InterContainerMessage) []chan *ca.InterContainerMessage {
+func removeChannel(channels []*subscriberChannel, ch <-chan *Envelope) []*subscriberChannel {
 	var i int
-	var channel chan *ca.InterContainerMessage
-	for i, channel = range channels {
-		if channel == ch {
+	var sub *subscriberChannel
+	for i, sub = range channels {
+		if sub.ch == ch {
 			channels[len(channels)-1], channels[i] = channels[i], channels[len(channels)-1]
-			close(channel)
+			sub.close()
 			return channels[:len(channels)-1]
 		}
 	}
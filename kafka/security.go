@@ -0,0 +1,93 @@
+/*
+ * Copyright 2018-present Open Networking Foundation
+
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+
+ * http://www.apache.org/licenses/LICENSE-2.0
+
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package kafka
+
+import (
+	"crypto/tls"
+	"fmt"
+
+	"github.com/xdg-go/scram"
+	"gopkg.in/Shopify/sarama.v1"
+)
+
+// SASLMechanism identifies a SASL authentication mechanism a SaramaClient can negotiate with the broker.
+type SASLMechanism string
+
+const (
+	SASLPlain       SASLMechanism = "PLAIN"
+	SASLScramSHA256 SASLMechanism = "SCRAM-SHA-256"
+	SASLScramSHA512 SASLMechanism = "SCRAM-SHA-512"
+)
+
+// applyTLS enables TLS on config using tlsConfig, which carries the broker's CA, and optionally client
+// certificates, so callers configure it the same way they would any other Go TLS client.
+func applyTLS(config *sarama.Config, tlsConfig *tls.Config) {
+	config.Net.TLS.Enable = true
+	config.Net.TLS.Config = tlsConfig
+}
+
+// applySASL enables SASL authentication on config for the given mechanism, wiring up the SCRAM client generator
+// when mechanism is one of the SCRAM variants. It returns an error for any mechanism it does not recognize so a
+// typo in the option is caught at Start() rather than surfacing as an opaque broker handshake failure.
+func applySASL(config *sarama.Config, mechanism SASLMechanism, user, password string) error {
+	config.Net.SASL.Enable = true
+	config.Net.SASL.User = user
+	config.Net.SASL.Password = password
+
+	switch mechanism {
+	case SASLPlain:
+		config.Net.SASL.Mechanism = sarama.SASLTypePlaintext
+	case SASLScramSHA256:
+		config.Net.SASL.Mechanism = sarama.SASLTypeSCRAMSHA256
+		config.Net.SASL.Handshake = true
+		config.Net.SASL.SCRAMClientGeneratorFunc = func() sarama.SCRAMClient {
+			return &scramClient{HashGeneratorFcn: scram.SHA256}
+		}
+	case SASLScramSHA512:
+		config.Net.SASL.Mechanism = sarama.SASLTypeSCRAMSHA512
+		config.Net.SASL.Handshake = true
+		config.Net.SASL.SCRAMClientGeneratorFunc = func() sarama.SCRAMClient {
+			return &scramClient{HashGeneratorFcn: scram.SHA512}
+		}
+	default:
+		return fmt.Errorf("unsupported-sasl-mechanism: %s", mechanism)
+	}
+	return nil
+}
+
+// scramClient adapts the xdg-go/scram library to sarama's SCRAMClient interface, which Shopify/sarama's SASL
+// handshake drives directly rather than going through any higher-level SCRAM abstraction.
+type scramClient struct {
+	HashGeneratorFcn scram.HashGeneratorFcn
+	conversation     *scram.ClientConversation
+}
+
+func (c *scramClient) Begin(userName, password, authzID string) error {
+	client, err := c.HashGeneratorFcn.NewClient(userName, password, authzID)
+	if err != nil {
+		return err
+	}
+	c.conversation = client.NewConversation()
+	return nil
+}
+
+func (c *scramClient) Step(challenge string) (string, error) {
+	return c.conversation.Step(challenge)
+}
+
+func (c *scramClient) Done() bool {
+	return c.conversation.Done()
+}
@@ -0,0 +1,75 @@
+// +build integration
+
+/*
+ * Copyright 2018-present Open Networking Foundation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package kafka
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+// These tests drive a real broker configured for TLS+SASL and only run with -tags integration against the
+// broker address, CA file and SASL credentials read from the KAFKA_TEST_* environment variables below, e.g. the
+// docker-compose broker under test/integration. They are skipped
+// by default so `go test ./...` stays hermetic.
+func tlsConfigFromEnv(t *testing.T) *tls.Config {
+	caFile := os.Getenv("KAFKA_TEST_CA_FILE")
+	if caFile == "" {
+		t.Skip("KAFKA_TEST_CA_FILE not set, skipping TLS+SASL integration test")
+	}
+	ca, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		t.Fatalf("failed to read KAFKA_TEST_CA_FILE: %v", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		t.Fatalf("failed to parse CA certificate from %s", caFile)
+	}
+	return &tls.Config{RootCAs: pool}
+}
+
+func newIntegrationClient(t *testing.T, mechanism SASLMechanism) *SaramaClient {
+	brokers := os.Getenv("KAFKA_TEST_BROKERS")
+	if brokers == "" {
+		t.Skip("KAFKA_TEST_BROKERS not set, skipping TLS+SASL integration test")
+	}
+	return NewSaramaClient(
+		Brokers([]string{brokers}),
+		TLSConfig(tlsConfigFromEnv(t)),
+		SASL(mechanism, os.Getenv("KAFKA_TEST_SASL_USER"), os.Getenv("KAFKA_TEST_SASL_PASSWORD")),
+	)
+}
+
+func TestSaramaClientStartsOverTLSWithSASLPlain(t *testing.T) {
+	client := newIntegrationClient(t, SASLPlain)
+	if err := client.Start(); err != nil {
+		t.Fatalf("Start() with SASL/PLAIN over TLS failed: %v", err)
+	}
+	client.Stop()
+}
+
+func TestSaramaClientStartsOverTLSWithSASLScramSHA512(t *testing.T) {
+	client := newIntegrationClient(t, SASLScramSHA512)
+	if err := client.Start(); err != nil {
+		t.Fatalf("Start() with SASL/SCRAM-SHA-512 over TLS failed: %v", err)
+	}
+	client.Stop()
+}
@@ -0,0 +1,177 @@
+/*
+ * Copyright 2018-present Open Networking Foundation
+
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+
+ * http://www.apache.org/licenses/LICENSE-2.0
+
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package kafka
+
+import (
+	"time"
+
+	"gopkg.in/Shopify/sarama.v1"
+)
+
+// RebalancePhase identifies whether a RebalanceCallback is reporting partition assignment or revocation.
+type RebalancePhase int
+
+const (
+	PartitionsAssigned RebalancePhase = iota
+	PartitionsRevoked
+)
+
+// RebalanceCallback is invoked on a GroupCustomer subscription whenever the consumer group's partition
+// assignment changes, with claims carrying the topic-to-partitions map sarama.ConsumerGroupSession.Claims()
+// reported for that phase. It lets a subscriber track which partitions it currently owns instead of only ever
+// seeing the message stream, e.g. to reset per-partition state on revocation.
+type RebalanceCallback func(phase RebalancePhase, claims map[string][]int32)
+
+// DefaultLivenessTimeout is how long a subscriber's channel can sit completely full before the watchdog
+// disconnects it, when no WithLivenessTimeout option overrides it. dispatchToConsumers spawns a goroutine per
+// subscriber per message and waits for every one to return before reporting dispatch latency, so a Block
+// subscriber whose reader has stopped entirely must never be allowed to block indefinitely: left unbounded, every
+// message pins one more goroutine pair forever. WithLivenessTimeout(0) opts back out of the watchdog for a
+// subscriber that is known to always keep up.
+const DefaultLivenessTimeout = 60 * time.Second
+
+// DefaultPartitionReconcileInterval is how often a PartitionConsumer mode subscription re-reads its topic's
+// partition list when no WithPartitionReconcileInterval option overrides it.
+const DefaultPartitionReconcileInterval = 30 * time.Second
+
+// SubscribeOptions configures a single Subscribe call, letting different subscribers of the same topic run
+// under different group ids, offsets, or timeouts instead of being forced through the client-wide
+// consumerType/groupName fields.
+type SubscribeOptions struct {
+	ConsumerType               int
+	GroupId                    string
+	InitialOffset              int64
+	SessionTimeout             time.Duration
+	RebalanceStrategy          string
+	RebalanceCallback          RebalanceCallback
+	MaxInFlight                int
+	DispatchPolicy             DispatchPolicy
+	LivenessTimeout            time.Duration
+	PartitionReconcileInterval time.Duration
+}
+
+// SubscribeOption customizes a SubscribeOptions built by Subscribe.
+type SubscribeOption func(*SubscribeOptions)
+
+// WithConsumerTypeOpt overrides the client-wide consumerType for this subscription only.
+func WithConsumerTypeOpt(consumerType int) SubscribeOption {
+	return func(o *SubscribeOptions) {
+		o.ConsumerType = consumerType
+	}
+}
+
+// WithGroupId selects the consumer group id used for a GroupCustomer subscription. Different subscribers can
+// use different group ids on the same topic to run as independent competing-consumer groups.
+func WithGroupId(groupId string) SubscribeOption {
+	return func(o *SubscribeOptions) {
+		o.GroupId = groupId
+	}
+}
+
+// WithInitialOffset overrides the offset a new partition consumer starts from. For GroupCustomer it only applies
+// to partitions for which the group has no committed offset yet.
+func WithInitialOffset(offset int64) SubscribeOption {
+	return func(o *SubscribeOptions) {
+		o.InitialOffset = offset
+	}
+}
+
+// WithSessionTimeout overrides how long the broker waits for a heartbeat before considering a group member dead.
+func WithSessionTimeout(timeout time.Duration) SubscribeOption {
+	return func(o *SubscribeOptions) {
+		o.SessionTimeout = timeout
+	}
+}
+
+// WithRebalanceStrategy selects the partition assignment strategy used during group rebalance (e.g. "range",
+// "roundrobin").
+func WithRebalanceStrategy(strategy string) SubscribeOption {
+	return func(o *SubscribeOptions) {
+		o.RebalanceStrategy = strategy
+	}
+}
+
+// WithRebalanceCallback registers cb to be notified of this GroupCustomer subscription's partition assignments
+// and revocations as its consumer group rebalances. Ignored for a PartitionConsumer subscription, which never
+// rebalances.
+func WithRebalanceCallback(cb RebalanceCallback) SubscribeOption {
+	return func(o *SubscribeOptions) {
+		o.RebalanceCallback = cb
+	}
+}
+
+// WithMaxInFlight bounds how many undelivered messages dispatchToConsumers will buffer for this subscriber's
+// channel before its DispatchPolicy kicks in.
+func WithMaxInFlight(max int) SubscribeOption {
+	return func(o *SubscribeOptions) {
+		o.MaxInFlight = max
+	}
+}
+
+// WithDispatchPolicy selects what dispatchToConsumers does when this subscriber's channel is already full of
+// undelivered messages: Block (the default), DropOldest, DropNewest, or DisconnectSlow.
+func WithDispatchPolicy(policy DispatchPolicy) SubscribeOption {
+	return func(o *SubscribeOptions) {
+		o.DispatchPolicy = policy
+	}
+}
+
+// WithLivenessTimeout overrides how long this subscriber's channel can sit completely full - meaning nothing is
+// draining it - before the dispatcher gives up on it, closing and removing it the same way a DisconnectSlow
+// policy would. Unlike DisconnectSlow, which only fires on the delivery attempt that finds the channel full,
+// this also catches a Block subscriber whose reader has stopped entirely. Pass 0 to disable the watchdog and
+// block forever instead, same as before DefaultLivenessTimeout became the default.
+func WithLivenessTimeout(timeout time.Duration) SubscribeOption {
+	return func(o *SubscribeOptions) {
+		o.LivenessTimeout = timeout
+	}
+}
+
+// WithPartitionReconcileInterval overrides how often a PartitionConsumer mode subscription re-reads its topic's
+// partition list to pick up partitions added since Subscribe was called. Ignored for a GroupCustomer
+// subscription, whose partitions sarama's ConsumerGroup already tracks via rebalancing.
+func WithPartitionReconcileInterval(interval time.Duration) SubscribeOption {
+	return func(o *SubscribeOptions) {
+		o.PartitionReconcileInterval = interval
+	}
+}
+
+// defaultSubscribeOptions seeds a SubscribeOptions from the client-wide defaults used by a Subscribe call with
+// no options: the client's consumerType, DefaultGroupName, a one-message Block channel, and the default liveness
+// and partition reconcile intervals.
+func (sc *SaramaClient) defaultSubscribeOptions() SubscribeOptions {
+	return SubscribeOptions{
+		ConsumerType:               sc.consumerType,
+		GroupId:                    DefaultGroupName,
+		InitialOffset:              sarama.OffsetNewest,
+		SessionTimeout:             DefaultSessionTimeout,
+		RebalanceStrategy:          "range",
+		MaxInFlight:                1,
+		DispatchPolicy:             Block,
+		LivenessTimeout:            DefaultLivenessTimeout,
+		PartitionReconcileInterval: DefaultPartitionReconcileInterval,
+	}
+}
+
+// consumerChannelKey identifies a subscription's slot in topicToConsumerChannelMap. Partition consumers are
+// keyed by topic alone; group consumers are additionally keyed by group id, so that multiple goroutines can
+// subscribe to the same topic under different group ids without clobbering one another.
+func consumerChannelKey(topic *Topic, opts SubscribeOptions) string {
+	if opts.ConsumerType == GroupCustomer {
+		return topic.Name + "/" + opts.GroupId
+	}
+	return topic.Name
+}
@@ -19,7 +19,6 @@ package device
 import (
 	"context"
 	"fmt"
-	"sync"
 
 	coreutils "github.com/opencord/voltha-go/rw_core/utils"
 	fu "github.com/opencord/voltha-lib-go/v3/pkg/flows"
@@ -56,22 +55,14 @@ func (agent *LogicalAgent) updateLogicalPort(ctx context.Context, device *voltha
 			return err
 		}
 	case voltha.Port_PON_OLT:
-		// Rebuilt the routes on Parent PON port addition
-		go func() {
-			if err := agent.buildRoutes(context.Background()); err != nil {
-				// Not an error - temporary state
-				logger.Infow(ctx, "failed-to-update-routes-after-adding-parent-pon-port", log.Fields{"device-id": device.Id, "port": port, "ports-count": len(devicePorts), "error": err})
-			}
-		}()
-		//fallthrough
+		// Routes are no longer rebuilt eagerly here - they are computed on demand, from the route
+		// cache, the next time a NNI<->UNI path is looked up. Just drop anything cached so a stale
+		// path cannot be returned.
+		agent.routeCache.invalidate()
 	case voltha.Port_PON_ONU:
-		// Add the routes corresponding to that child device
-		go func() {
-			if err := agent.updateAllRoutes(context.Background(), device.Id, devicePorts); err != nil {
-				// Not an error - temporary state
-				logger.Infow(ctx, "failed-to-update-routes-after-adding-child-pon-port", log.Fields{"device-id": device.Id, "port": port, "ports-count": len(devicePorts), "error": err})
-			}
-		}()
+		// Same as above - the child device's ports may affect existing paths, so invalidate rather
+		// than recompute.
+		agent.routeCache.invalidate()
 	default:
 		return fmt.Errorf("invalid port type %v", port)
 	}
@@ -119,6 +110,9 @@ func (agent *LogicalAgent) setupLogicalPorts(ctx context.Context) error {
 	if res := coreutils.WaitForNilOrErrorResponses(agent.defaultTimeout, responses...); res != nil {
 		return status.Errorf(codes.Aborted, "errors-%s", res)
 	}
+
+	// The initial port set is in place; start polling every logical port for counters.
+	agent.StartPortStatsCollection(0)
 	return nil
 }
 
@@ -134,13 +128,15 @@ func (agent *LogicalAgent) setupNNILogicalPorts(ctx context.Context, deviceID st
 	}
 
 	//Get UNI port number
+	nniPorts := make([]*voltha.LogicalPort, 0)
 	for _, port := range devicePorts {
 		if port.Type == voltha.Port_ETHERNET_NNI {
-			if err = agent.addNNILogicalPort(ctx, deviceID, devicePorts, port); err != nil {
-				logger.Errorw(ctx, "error-adding-NNI-port", log.Fields{"error": err})
-			}
+			nniPorts = append(nniPorts, agent.buildNNILogicalPort(deviceID, port))
 		}
 	}
+	if err = agent.addLogicalPorts(ctx, nniPorts); err != nil {
+		logger.Errorw(ctx, "error-adding-NNI-ports", log.Fields{"error": err})
+	}
 	return err
 }
 
@@ -158,7 +154,9 @@ func (agent *LogicalAgent) updatePortState(ctx context.Context, portNo uint32, o
 	if err := portHandle.Update(ctx, newPort); err != nil {
 		return err
 	}
-	agent.orderedEvents.send(ctx, agent, agent.logicalDeviceID, ofp.OfpPortReason_OFPPR_MODIFY, newPort.OfpPort)
+	// The per-port lock we are already holding is what now guarantees that a MODIFY event cannot be
+	// reordered against another event for the same port.
+	agent.emitPortEvent(ctx, ofp.OfpPortReason_OFPPR_MODIFY, newPort.OfpPort)
 	return nil
 }
 
@@ -180,75 +178,90 @@ func clonePortSetState(oldPort *voltha.LogicalPort, state voltha.OperStatus_Type
 // setupUNILogicalPorts creates a UNI port on the logical device that represents a child UNI interface
 func (agent *LogicalAgent) setupUNILogicalPorts(ctx context.Context, childDevice *voltha.Device, childDevicePorts map[uint32]*voltha.Port) error {
 	logger.Infow(ctx, "setupUNILogicalPort", log.Fields{"logicalDeviceId": agent.logicalDeviceID})
+	if childDevice.AdminState != voltha.AdminState_ENABLED || childDevice.OperStatus != voltha.OperStatus_ACTIVE {
+		logger.Infow(ctx, "device-not-ready", log.Fields{"deviceId": childDevice.Id, "admin": childDevice.AdminState, "oper": childDevice.OperStatus})
+		return nil
+	}
+
 	// Build the logical device based on information retrieved from the device adapter
-	var err error
-	//Get UNI port number
+	uniPorts := make([]*voltha.LogicalPort, 0)
 	for _, port := range childDevicePorts {
 		if port.Type == voltha.Port_ETHERNET_UNI {
-			if err = agent.addUNILogicalPort(ctx, childDevice.Id, childDevice.AdminState, childDevice.OperStatus, childDevicePorts, port); err != nil {
-				logger.Errorw(ctx, "error-adding-UNI-port", log.Fields{"error": err})
-			}
+			uniPorts = append(uniPorts, agent.buildUNILogicalPort(childDevice.Id, port))
 		}
 	}
-	return err
+	if err := agent.addLogicalPorts(ctx, uniPorts); err != nil {
+		logger.Errorw(ctx, "error-adding-UNI-ports", log.Fields{"error": err})
+		return err
+	}
+	return nil
 }
 
 // deleteAllLogicalPorts deletes all logical ports associated with this logical device
 func (agent *LogicalAgent) deleteAllLogicalPorts(ctx context.Context) error {
 	logger.Infow(ctx, "updatePortsState-start", log.Fields{"logicalDeviceId": agent.logicalDeviceID})
 
-	// for each port
+	// No ports means nothing left to poll.
+	agent.StopPortStatsCollection()
+
+	portNos := make([]uint32, 0)
 	for portID := range agent.portLoader.ListIDs() {
-		// TODO: can just call agent.deleteLogicalPort()?
-		if portHandle, have := agent.portLoader.Lock(portID); have {
-			oldPort := portHandle.GetReadOnly()
-			// delete
-			err := portHandle.Delete(ctx)
-			portHandle.Unlock()
-			if err != nil {
-				return err
-			}
-			// and send event
-			agent.orderedEvents.send(ctx, agent, agent.logicalDeviceID, ofp.OfpPortReason_OFPPR_DELETE, oldPort.OfpPort)
-		}
+		portNos = append(portNos, portID)
 	}
-
-	// Reset the logical device routes
-	go func() {
-		if err := agent.buildRoutes(context.Background()); err != nil {
-			logger.Warnw(ctx, "device-routes-not-ready", log.Fields{"logicalDeviceId": agent.logicalDeviceID, "error": err})
-		}
-	}()
-	return nil
+	return agent.deleteLogicalPorts(ctx, portNos, "")
 }
 
-// deleteLogicalPorts removes the logical ports associated with that deviceId
-func (agent *LogicalAgent) deleteLogicalPorts(ctx context.Context, deviceID string) error {
+// deleteLogicalPortsForDevice removes the logical ports associated with that deviceId
+func (agent *LogicalAgent) deleteLogicalPortsForDevice(ctx context.Context, deviceID string) error {
 	logger.Debugw(ctx, "deleting-logical-ports", log.Fields{"device-id": deviceID})
 
-	// for each port
+	portNos := make([]uint32, 0)
 	for portNo := range agent.portLoader.ListIDsForDevice(deviceID) {
-		if portHandle, have := agent.portLoader.Lock(portNo); have {
-			// if belongs to this device
-			if oldPort := portHandle.GetReadOnly(); oldPort.DeviceId == deviceID {
-				// delete
-				if err := portHandle.Delete(ctx); err != nil {
-					portHandle.Unlock()
-					return err
-				}
-				// and send event
-				agent.orderedEvents.send(ctx, agent, agent.logicalDeviceID, ofp.OfpPortReason_OFPPR_DELETE, oldPort.OfpPort)
-			}
+		portNos = append(portNos, portNo)
+	}
+	return agent.deleteLogicalPorts(ctx, portNos, deviceID)
+}
+
+// deleteLogicalPorts removes the given logical ports in a single pass, taking each port's portLoader lock only
+// once, invalidating the route cache a single time once all ports are gone, and emitting the resulting
+// OFPPR_DELETE events as one contiguous block. deviceID, if non-empty, re-checks each port still belongs to that
+// device once its lock is held, since portNos was snapshotted before any lock was taken and a port can be
+// reassigned to a different device in that window; pass "" (from deleteAllLogicalPorts) to delete unconditionally.
+func (agent *LogicalAgent) deleteLogicalPorts(ctx context.Context, portNos []uint32, deviceID string) error {
+	if len(portNos) == 0 {
+		return nil
+	}
+
+	deleted := make([]*voltha.LogicalPort, 0, len(portNos))
+	for _, portNo := range portNos {
+		portHandle, have := agent.portLoader.Lock(portNo)
+		if !have {
+			continue
+		}
+		oldPort := portHandle.GetReadOnly()
+		if deviceID != "" && oldPort.DeviceId != deviceID {
+			// Reassigned to a different device since the ListIDsForDevice snapshot; not ours to delete.
 			portHandle.Unlock()
+			continue
 		}
+		err := portHandle.Delete(ctx)
+		portHandle.Unlock()
+		if err != nil {
+			return err
+		}
+		deleted = append(deleted, oldPort)
 	}
 
-	// Reset the logical device routes
-	go func() {
-		if err := agent.buildRoutes(context.Background()); err != nil {
-			logger.Warnw(ctx, "routes-not-ready", log.Fields{"logical-device-id": agent.logicalDeviceID, "error": err})
-		}
-	}()
+	if len(deleted) == 0 {
+		return nil
+	}
+
+	// Any cached NNI<->UNI path may reference one of the now-deleted ports
+	agent.routeCache.invalidate()
+
+	for _, oldPort := range deleted {
+		agent.emitPortEvent(ctx, ofp.OfpPortReason_OFPPR_DELETE, oldPort.OfpPort)
+	}
 	return nil
 }
 
@@ -270,7 +283,7 @@ func (agent *LogicalAgent) enableLogicalPort(ctx context.Context, lPortNo uint32
 	if err := portHandle.Update(ctx, &newPort); err != nil {
 		return err
 	}
-	agent.orderedEvents.send(ctx, agent, agent.logicalDeviceID, ofp.OfpPortReason_OFPPR_MODIFY, newPort.OfpPort)
+	agent.emitPortEvent(ctx, ofp.OfpPortReason_OFPPR_MODIFY, newPort.OfpPort)
 	return nil
 }
 
@@ -292,23 +305,19 @@ func (agent *LogicalAgent) disableLogicalPort(ctx context.Context, lPortNo uint3
 	if err := portHandle.Update(ctx, &newPort); err != nil {
 		return err
 	}
-	agent.orderedEvents.send(ctx, agent, agent.logicalDeviceID, ofp.OfpPortReason_OFPPR_MODIFY, newPort.OfpPort)
+	agent.emitPortEvent(ctx, ofp.OfpPortReason_OFPPR_MODIFY, newPort.OfpPort)
 	return nil
 }
 
-// addNNILogicalPort adds an NNI port to the logical device.  It returns a bool representing whether a port has been
-// added and an error in case a valid error is encountered. If the port was successfully added it will return
-// (true, nil).   If the device is not in the correct state it will return (false, nil) as this is a valid
-// scenario. This also applies to the case where the port was already added.
-func (agent *LogicalAgent) addNNILogicalPort(ctx context.Context, deviceID string, devicePorts map[uint32]*voltha.Port, port *voltha.Port) error {
-	logger.Debugw(ctx, "addNNILogicalPort", log.Fields{"logical-device-id": agent.logicalDeviceID, "nni-port": port})
-
+// buildNNILogicalPort assembles the voltha.LogicalPort that represents an NNI interface on a root device, ready
+// to be handed to addLogicalPorts.
+func (agent *LogicalAgent) buildNNILogicalPort(deviceID string, port *voltha.Port) *voltha.LogicalPort {
 	label := fmt.Sprintf("nni-%d", port.PortNo)
 	ofpPort := *port.OfpPort
 	ofpPort.HwAddr = append([]uint32{}, port.OfpPort.HwAddr...)
 	ofpPort.PortNo = port.PortNo
 	ofpPort.Name = label
-	nniPort := &voltha.LogicalPort{
+	return &voltha.LogicalPort{
 		RootPort:     true,
 		DeviceId:     deviceID,
 		Id:           label,
@@ -316,50 +325,15 @@ func (agent *LogicalAgent) addNNILogicalPort(ctx context.Context, deviceID strin
 		OfpPort:      &ofpPort,
 		OfpPortStats: &ofp.OfpPortStats{},
 	}
-
-	portHandle, created, err := agent.portLoader.LockOrCreate(ctx, nniPort)
-	if err != nil {
-		return err
-	}
-	defer portHandle.Unlock()
-
-	if !created {
-		logger.Debugw(ctx, "port-already-exist", log.Fields{"port": port})
-		return nil
-	}
-
-	// ensure that no events will be sent until this one is
-	queuePosition := agent.orderedEvents.assignQueuePosition()
-
-	// Setup the routes for this device and then send the port update event to the OF Controller
-	go func() {
-		// First setup the routes
-		if err := agent.updateRoutes(context.Background(), deviceID, devicePorts, nniPort, agent.listLogicalDevicePorts(ctx)); err != nil {
-			// This is not an error as we may not have enough logical ports to set up routes or some PON ports have not been
-			// created yet.
-			logger.Infow(ctx, "routes-not-ready", log.Fields{"logical-device-id": agent.logicalDeviceID, "logical-port": nniPort.OfpPort.PortNo, "error": err})
-		}
-
-		// send event, and allow any queued events to be sent as well
-		queuePosition.send(ctx, agent, agent.logicalDeviceID, ofp.OfpPortReason_OFPPR_ADD, nniPort.OfpPort)
-	}()
-	return nil
 }
 
-// addUNILogicalPort adds an UNI port to the logical device.  It returns a bool representing whether a port has been
-// added and an error in case a valid error is encountered. If the port was successfully added it will return
-// (true, nil).   If the device is not in the correct state it will return (false, nil) as this is a valid
-// scenario. This also applies to the case where the port was already added.
-func (agent *LogicalAgent) addUNILogicalPort(ctx context.Context, deviceID string, deviceAdminState voltha.AdminState_Types, deviceOperStatus voltha.OperStatus_Types, devicePorts map[uint32]*voltha.Port, port *voltha.Port) error {
-	logger.Debugw(ctx, "addUNILogicalPort", log.Fields{"port": port})
-	if deviceAdminState != voltha.AdminState_ENABLED || deviceOperStatus != voltha.OperStatus_ACTIVE {
-		logger.Infow(ctx, "device-not-ready", log.Fields{"deviceId": deviceID, "admin": deviceAdminState, "oper": deviceOperStatus})
-		return nil
-	}
+// buildUNILogicalPort assembles the voltha.LogicalPort that represents a UNI interface on a child device, ready
+// to be handed to addLogicalPorts.
+func (agent *LogicalAgent) buildUNILogicalPort(deviceID string, port *voltha.Port) *voltha.LogicalPort {
 	ofpPort := *port.OfpPort
 	ofpPort.HwAddr = append([]uint32{}, port.OfpPort.HwAddr...)
 	ofpPort.PortNo = port.PortNo
-	uniPort := &voltha.LogicalPort{
+	return &voltha.LogicalPort{
 		RootPort:     false,
 		DeviceId:     deviceID,
 		Id:           port.Label,
@@ -367,77 +341,61 @@ func (agent *LogicalAgent) addUNILogicalPort(ctx context.Context, deviceID strin
 		OfpPort:      &ofpPort,
 		OfpPortStats: &ofp.OfpPortStats{},
 	}
+}
 
-	portHandle, created, err := agent.portLoader.LockOrCreate(ctx, uniPort)
-	if err != nil {
-		return err
-	}
-	defer portHandle.Unlock()
+// addNNILogicalPort adds a single NNI port to the logical device.  It returns nil both when the port was
+// successfully added and when the port already existed, since the latter is a valid scenario.
+func (agent *LogicalAgent) addNNILogicalPort(ctx context.Context, deviceID string, devicePorts map[uint32]*voltha.Port, port *voltha.Port) error {
+	logger.Debugw(ctx, "addNNILogicalPort", log.Fields{"logical-device-id": agent.logicalDeviceID, "nni-port": port})
+	return agent.addLogicalPorts(ctx, []*voltha.LogicalPort{agent.buildNNILogicalPort(deviceID, port)})
+}
 
-	if !created {
-		logger.Debugw(ctx, "port-already-exist", log.Fields{"port": port})
+// addUNILogicalPort adds a single UNI port to the logical device.  It returns nil both when the port was
+// successfully added and when the device is not yet ready, since the latter is a valid scenario.
+func (agent *LogicalAgent) addUNILogicalPort(ctx context.Context, deviceID string, deviceAdminState voltha.AdminState_Types, deviceOperStatus voltha.OperStatus_Types, devicePorts map[uint32]*voltha.Port, port *voltha.Port) error {
+	logger.Debugw(ctx, "addUNILogicalPort", log.Fields{"port": port})
+	if deviceAdminState != voltha.AdminState_ENABLED || deviceOperStatus != voltha.OperStatus_ACTIVE {
+		logger.Infow(ctx, "device-not-ready", log.Fields{"deviceId": deviceID, "admin": deviceAdminState, "oper": deviceOperStatus})
 		return nil
 	}
-
-	// ensure that no events will be sent until this one is
-	queuePosition := agent.orderedEvents.assignQueuePosition()
-
-	// Setup the routes for this device and then send the port update event to the OF Controller
-	go func() {
-		// First setup the routes
-		if err := agent.updateRoutes(context.Background(), deviceID, devicePorts, uniPort, agent.listLogicalDevicePorts(ctx)); err != nil {
-			// This is not an error as we may not have enough logical ports to set up routes or some PON ports have not been
-			// created yet.
-			logger.Infow(ctx, "routes-not-ready", log.Fields{"logical-device-id": agent.logicalDeviceID, "logical-port": uniPort.OfpPort.PortNo, "error": err})
-		}
-
-		// send event, and allow any queued events to be sent as well
-		queuePosition.send(context.Background(), agent, agent.logicalDeviceID, ofp.OfpPortReason_OFPPR_ADD, uniPort.OfpPort)
-	}()
-	return nil
+	return agent.addLogicalPorts(ctx, []*voltha.LogicalPort{agent.buildUNILogicalPort(deviceID, port)})
 }
 
-// send is a convenience to avoid calling both assignQueuePosition and qp.send
-func (e *orderedEvents) send(ctx context.Context, agent *LogicalAgent, deviceID string, reason ofp.OfpPortReason, desc *ofp.OfpPort) {
-	qp := e.assignQueuePosition()
-	go qp.send(context.Background(), agent, deviceID, reason, desc)
-}
+// addLogicalPorts persists every given logical port in a single pass, taking each port's portLoader lock only
+// once, invalidating the route cache a single time once all ports are in place, and emitting the resulting
+// OFPPR_ADD events as one contiguous block. This avoids the O(N^2) behaviour of adding ports one at a time,
+// which is particularly costly for OLTs that bring up hundreds of UNIs at once.
+func (agent *LogicalAgent) addLogicalPorts(ctx context.Context, ports []*voltha.LogicalPort) error {
+	if len(ports) == 0 {
+		return nil
+	}
 
-// TODO: shouldn't need to guarantee event ordering like this
-//       event ordering should really be protected by per-LogicalPort lock
-//       once routing uses on-demand calculation only, this should be changed
-// assignQueuePosition ensures that no events will be sent until this thread calls send() on the returned queuePosition
-func (e *orderedEvents) assignQueuePosition() queuePosition {
-	e.mutex.Lock()
-	defer e.mutex.Unlock()
-
-	prev := e.last
-	next := make(chan struct{})
-	e.last = next
-	return queuePosition{
-		prev: prev,
-		next: next,
+	added := make([]*voltha.LogicalPort, 0, len(ports))
+	for _, port := range ports {
+		portHandle, created, err := agent.portLoader.LockOrCreate(ctx, port)
+		if err != nil {
+			return err
+		}
+		if created {
+			added = append(added, port)
+		} else {
+			logger.Debugw(ctx, "port-already-exist", log.Fields{"port": port})
+		}
+		portHandle.Unlock()
 	}
-}
 
-// orderedEvents guarantees the order that events are sent, while allowing events to back up.
-type orderedEvents struct {
-	mutex sync.Mutex
-	last  <-chan struct{}
-}
+	if len(added) == 0 {
+		return nil
+	}
 
-type queuePosition struct {
-	prev <-chan struct{}
-	next chan<- struct{}
-}
+	// Routes involving these ports are computed lazily, on first lookup, so there is nothing to build here.
+	// A single invalidation covers the whole batch.
+	agent.routeCache.invalidate()
 
-// send waits for its turn, then sends the event, then notifies the next in line
-func (qp queuePosition) send(ctx context.Context, agent *LogicalAgent, deviceID string, reason ofp.OfpPortReason, desc *ofp.OfpPort) {
-	if qp.prev != nil {
-		<-qp.prev // wait for turn
+	for _, port := range added {
+		agent.emitPortEvent(ctx, ofp.OfpPortReason_OFPPR_ADD, port.OfpPort)
 	}
-	agent.ldeviceMgr.SendChangeEvent(ctx, deviceID, reason, desc)
-	close(qp.next) // notify next
+	return nil
 }
 
 // GetWildcardInputPorts filters out the logical port number from the set of logical ports on the device and
@@ -0,0 +1,111 @@
+/*
+ * Copyright 2018-present Open Networking Foundation
+
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+
+ * http://www.apache.org/licenses/LICENSE-2.0
+
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package device
+
+import (
+	"context"
+	"sync"
+
+	"github.com/opencord/voltha-lib-go/v3/pkg/log"
+	ofp "github.com/opencord/voltha-protos/v3/go/openflow_13"
+)
+
+// portEventSubscriberBuffer is the number of undelivered events a single subscriber can accumulate before the
+// oldest one is dropped to make room for the newest.
+const portEventSubscriberBuffer = 64
+
+// PortEvent describes a single OFPPR_ADD/MODIFY/DELETE transition of a logical port, as delivered to a
+// SubscribePortEvents consumer.
+type PortEvent struct {
+	LogicalDeviceID string
+	Reason          ofp.OfpPortReason
+	Desc            *ofp.OfpPort
+}
+
+// portEventBroker fans out port events to any number of in-process subscribers, such as telemetry exporters or
+// test harnesses, in addition to the single SendChangeEvent sink used to notify the OF controller. A slow
+// subscriber cannot stall delivery to anyone else, or to SendChangeEvent itself, because each subscriber gets
+// its own bounded, drop-oldest buffer.
+type portEventBroker struct {
+	mutex       sync.Mutex
+	subscribers map[chan PortEvent]struct{}
+}
+
+func newPortEventBroker() *portEventBroker {
+	return &portEventBroker{
+		subscribers: make(map[chan PortEvent]struct{}),
+	}
+}
+
+// subscribe registers a new consumer and returns its channel along with an unsubscribe function.
+func (b *portEventBroker) subscribe() (<-chan PortEvent, func()) {
+	ch := make(chan PortEvent, portEventSubscriberBuffer)
+
+	b.mutex.Lock()
+	b.subscribers[ch] = struct{}{}
+	b.mutex.Unlock()
+
+	unsubscribe := func() {
+		b.mutex.Lock()
+		defer b.mutex.Unlock()
+		if _, have := b.subscribers[ch]; have {
+			delete(b.subscribers, ch)
+			close(ch)
+		}
+	}
+	return ch, unsubscribe
+}
+
+// publish delivers the event to every subscriber. A subscriber whose buffer is full has its oldest queued event
+// dropped to make room, so publish itself never blocks on a stalled consumer.
+func (b *portEventBroker) publish(event PortEvent) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	for ch := range b.subscribers {
+		select {
+		case ch <- event:
+		default:
+			// drop the oldest queued event for this subscriber, then retry
+			select {
+			case <-ch:
+			default:
+			}
+			select {
+			case ch <- event:
+			default:
+			}
+		}
+	}
+}
+
+// SubscribePortEvents registers the caller as a consumer of this logical device's port lifecycle events. The
+// returned function must be called to unsubscribe and release the underlying channel.
+func (agent *LogicalAgent) SubscribePortEvents(ctx context.Context) (<-chan PortEvent, func()) {
+	logger.Debugw(ctx, "subscribe-port-events", log.Fields{"logical-device-id": agent.logicalDeviceID})
+	return agent.portEvents.subscribe()
+}
+
+// emitPortEvent is the single place a port lifecycle transition is reported: it notifies the OF controller via
+// the existing SendChangeEvent sink and fans the same event out to every SubscribePortEvents consumer.
+func (agent *LogicalAgent) emitPortEvent(ctx context.Context, reason ofp.OfpPortReason, desc *ofp.OfpPort) {
+	agent.ldeviceMgr.SendChangeEvent(ctx, agent.logicalDeviceID, reason, desc)
+	agent.portEvents.publish(PortEvent{
+		LogicalDeviceID: agent.logicalDeviceID,
+		Reason:          reason,
+		Desc:            desc,
+	})
+}
@@ -0,0 +1,185 @@
+/*
+ * Copyright 2018-present Open Networking Foundation
+
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+
+ * http://www.apache.org/licenses/LICENSE-2.0
+
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package device
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/opencord/voltha-lib-go/v3/pkg/log"
+	ofp "github.com/opencord/voltha-protos/v3/go/openflow_13"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// defaultPortStatsPollInterval is used when StartPortStatsCollection is given no explicit (positive) interval
+const defaultPortStatsPollInterval = 15 * time.Second
+
+// portStatsCollectors tracks each LogicalAgent's running portStatsCollector, keyed by agent. The collector's
+// lifecycle belongs to LogicalAgent, but LogicalAgent's own struct definition and constructor live outside this
+// file, so there is no field on LogicalAgent itself to hold this; a package-level registry serves the same
+// purpose without requiring a change to that struct.
+var (
+	portStatsCollectorsMu sync.Mutex
+	portStatsCollectors   = make(map[*LogicalAgent]*portStatsCollector)
+)
+
+// StartPortStatsCollection starts polling agent's ports for counters every pollInterval (0 selects
+// defaultPortStatsPollInterval), merging results into the portLoader as they arrive. Called from
+// setupLogicalPorts once the logical device's ports are in place; a no-op if collection is already running for
+// agent.
+func (agent *LogicalAgent) StartPortStatsCollection(pollInterval time.Duration) {
+	portStatsCollectorsMu.Lock()
+	defer portStatsCollectorsMu.Unlock()
+	if _, running := portStatsCollectors[agent]; running {
+		return
+	}
+	c := newPortStatsCollector(agent, pollInterval)
+	portStatsCollectors[agent] = c
+	go c.start(context.Background())
+}
+
+// StopPortStatsCollection stops agent's port stats collector, if one is running. Called from deleteAllLogicalPorts
+// as the logical device is torn down.
+func (agent *LogicalAgent) StopPortStatsCollection() {
+	portStatsCollectorsMu.Lock()
+	c, running := portStatsCollectors[agent]
+	if running {
+		delete(portStatsCollectors, agent)
+	}
+	portStatsCollectorsMu.Unlock()
+	if running {
+		c.stop()
+	}
+}
+
+// portStatsCollector periodically polls every logical port of a logical device, NNI and UNI alike, for counters and merges
+// them into the portLoader's OfpPortStats, so that OFPMP_PORT_STATS replies reflect real adapter-reported values.
+type portStatsCollector struct {
+	agent        *LogicalAgent
+	pollInterval time.Duration
+	stopped      chan struct{}
+}
+
+// newPortStatsCollector creates a collector for the given logical agent.  A zero pollInterval falls back to
+// defaultPortStatsPollInterval.
+func newPortStatsCollector(agent *LogicalAgent, pollInterval time.Duration) *portStatsCollector {
+	if pollInterval <= 0 {
+		pollInterval = defaultPortStatsPollInterval
+	}
+	return &portStatsCollector{
+		agent:        agent,
+		pollInterval: pollInterval,
+		stopped:      make(chan struct{}),
+	}
+}
+
+// start runs the polling loop until stop is called.  A single ticker fires every pollInterval, and each port's
+// poll is then delayed by a random fraction of that interval so that a logical device with many ports does not
+// hammer every adapter at the same instant.
+func (c *portStatsCollector) start(ctx context.Context) {
+	logger.Infow(ctx, "starting-port-stats-collector", log.Fields{"logical-device-id": c.agent.logicalDeviceID, "poll-interval": c.pollInterval})
+	ticker := time.NewTicker(c.pollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.stopped:
+			logger.Infow(ctx, "stopping-port-stats-collector", log.Fields{"logical-device-id": c.agent.logicalDeviceID})
+			return
+		case <-ticker.C:
+			c.pollAllPorts(context.Background())
+		}
+	}
+}
+
+// stop terminates the polling loop.
+func (c *portStatsCollector) stop() {
+	close(c.stopped)
+}
+
+// pollAllPorts fans out a jittered poll of every port currently known to the portLoader, so that ports are not
+// all queried on the adapter in lockstep.
+func (c *portStatsCollector) pollAllPorts(ctx context.Context) {
+	for portNo := range c.agent.portLoader.ListIDs() {
+		portNo := portNo
+		delay := time.Duration(rand.Int63n(int64(c.pollInterval)))
+		go func() {
+			time.Sleep(delay)
+			if err := c.agent.refreshPortStats(ctx, portNo); err != nil {
+				logger.Debugw(ctx, "failed-to-refresh-port-stats", log.Fields{"logical-device-id": c.agent.logicalDeviceID, "port-no": portNo, "error": err})
+			}
+		}()
+	}
+}
+
+// refreshPortStats polls the owning adapter for a single port's counters and merges the result into the
+// portLoader under the existing per-port lock.
+func (agent *LogicalAgent) refreshPortStats(ctx context.Context, portNo uint32) error {
+	portHandle, have := agent.portLoader.Lock(portNo)
+	if !have {
+		return status.Errorf(codes.NotFound, "port-%d-not-exist", portNo)
+	}
+	defer portHandle.Unlock()
+
+	oldPort := portHandle.GetReadOnly()
+	// adapterProxy.GetPortStats calls the IAdapter RPC of the same name on the adapter that owns oldPort.DeviceId.
+	// Both adapterProxy and the IAdapter interface it implements are defined in github.com/opencord/voltha-lib-go,
+	// an external dependency not vendored into this source tree, so the RPC itself cannot be added from here
+	// without editing a module outside this repository's scope.
+	stats, err := agent.deviceMgr.adapterProxy.GetPortStats(ctx, oldPort.DeviceId, oldPort.DevicePortNo)
+	if err != nil {
+		return err
+	}
+
+	newPort := *oldPort // only clone the struct(s) that will be changed
+	newPort.OfpPortStats = stats
+	return portHandle.Update(ctx, &newPort)
+}
+
+// GetPortStats returns the last polled counters for a single logical port.
+func (agent *LogicalAgent) GetPortStats(ctx context.Context, portNo uint32) (*ofp.OfpPortStats, error) {
+	portHandle, have := agent.portLoader.Lock(portNo)
+	if !have {
+		return nil, status.Errorf(codes.NotFound, "port-%d-not-exist", portNo)
+	}
+	defer portHandle.Unlock()
+	return portHandle.GetReadOnly().OfpPortStats, nil
+}
+
+// ListPortStats returns the last polled counters for every logical port, keyed by port number.  It backs the
+// OFPMP_PORT_STATS multipart reply.
+func (agent *LogicalAgent) ListPortStats(ctx context.Context) map[uint32]*ofp.OfpPortStats {
+	ports := agent.listLogicalDevicePorts(ctx)
+	stats := make(map[uint32]*ofp.OfpPortStats, len(ports))
+	for portNo, port := range ports {
+		stats[portNo] = port.OfpPortStats
+	}
+	return stats
+}
+
+// ListPortDesc returns the OfpPort description for every logical port.  It backs the OFPMP_PORT_DESC multipart
+// reply.
+func (agent *LogicalAgent) ListPortDesc(ctx context.Context) map[uint32]*ofp.OfpPort {
+	ports := agent.listLogicalDevicePorts(ctx)
+	desc := make(map[uint32]*ofp.OfpPort, len(ports))
+	for portNo, port := range ports {
+		desc[portNo] = port.OfpPort
+	}
+	return desc
+}
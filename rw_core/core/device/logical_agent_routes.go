@@ -0,0 +1,136 @@
+/*
+ * Copyright 2018-present Open Networking Foundation
+
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+
+ * http://www.apache.org/licenses/LICENSE-2.0
+
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package device
+
+import (
+	"context"
+	"sync"
+
+	"github.com/opencord/voltha-lib-go/v3/pkg/log"
+	"github.com/opencord/voltha-protos/v3/go/voltha"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// route describes the device-to-device hops a flow must traverse between two logical ports.
+type route []graphHop
+
+// graphHop is a single device along a route
+type graphHop struct {
+	DeviceID string
+	Ingress  uint32
+	Egress   uint32
+}
+
+// routeKey identifies a route by its logical in/out port pair
+type routeKey struct {
+	inPortNo  uint32
+	outPortNo uint32
+}
+
+// routeCache lazily computes and memoizes NNI<->UNI routes.  It replaces the old eager buildRoutes/updateRoutes
+// goroutines: a route is only ever computed the first time it is looked up, and the whole cache is invalidated
+// whenever a port is added or removed rather than being kept incrementally up to date.
+type routeCache struct {
+	agent *LogicalAgent
+
+	mutex  sync.RWMutex
+	routes map[routeKey]route
+}
+
+func newRouteCache(agent *LogicalAgent) *routeCache {
+	return &routeCache{
+		agent:  agent,
+		routes: make(map[routeKey]route),
+	}
+}
+
+// invalidate drops every cached route.  It is called any time a logical port is added or removed, since either
+// event can change which paths are valid.
+func (rc *routeCache) invalidate() {
+	rc.mutex.Lock()
+	defer rc.mutex.Unlock()
+	rc.routes = make(map[routeKey]route)
+}
+
+// get returns the route between two logical ports, computing and caching it on first use.
+func (rc *routeCache) get(ctx context.Context, inPortNo, outPortNo uint32) (route, error) {
+	key := routeKey{inPortNo: inPortNo, outPortNo: outPortNo}
+
+	rc.mutex.RLock()
+	if r, have := rc.routes[key]; have {
+		rc.mutex.RUnlock()
+		return r, nil
+	}
+	rc.mutex.RUnlock()
+
+	r, err := rc.agent.computeRoute(ctx, inPortNo, outPortNo)
+	if err != nil {
+		return nil, err
+	}
+
+	rc.mutex.Lock()
+	rc.routes[key] = r
+	rc.mutex.Unlock()
+	return r, nil
+}
+
+// GetRoute returns the route a flow between inPortNo and outPortNo on this logical device must take, for flow
+// decomposition to turn a logical flow into the per-device flows that implement it. It is the public entry point
+// routeCache.get replaced buildRoutes/updateRoutes with: those precomputed every route into a table whenever the
+// port set changed, where this instead computes (and memoizes) a route only the first time it is actually needed.
+func (agent *LogicalAgent) GetRoute(ctx context.Context, inPortNo, outPortNo uint32) (route, error) {
+	return agent.routeCache.get(ctx, inPortNo, outPortNo)
+}
+
+// computeRoute walks the logical device's NNI and UNI ports to build the path between the two given ports.  It
+// replaces the work that used to happen eagerly, in the background, via buildRoutes/updateRoutes.
+func (agent *LogicalAgent) computeRoute(ctx context.Context, inPortNo, outPortNo uint32) (route, error) {
+	logger.Debugw(ctx, "computing-route", log.Fields{"logical-device-id": agent.logicalDeviceID, "in-port": inPortNo, "out-port": outPortNo})
+
+	inHandle, have := agent.portLoader.Lock(inPortNo)
+	if !have {
+		return nil, status.Errorf(codes.NotFound, "port-%d-not-exist", inPortNo)
+	}
+	inPort := inHandle.GetReadOnly()
+	inHandle.Unlock()
+
+	outHandle, have := agent.portLoader.Lock(outPortNo)
+	if !have {
+		return nil, status.Errorf(codes.NotFound, "port-%d-not-exist", outPortNo)
+	}
+	outPort := outHandle.GetReadOnly()
+	outHandle.Unlock()
+
+	// A route between an NNI and a UNI is just a single hop through the child (ONU/ONT) device that owns the
+	// UNI port; traffic between two UNIs, or two NNIs, on this logical device is not routable.
+	var childPort *voltha.LogicalPort
+	switch {
+	case inPort.RootPort && !outPort.RootPort:
+		childPort = outPort
+	case !inPort.RootPort && outPort.RootPort:
+		childPort = inPort
+	default:
+		return nil, status.Errorf(codes.FailedPrecondition, "no-route-between-ports-%d-and-%d", inPortNo, outPortNo)
+	}
+
+	return route{{
+		DeviceID: childPort.DeviceId,
+		Ingress:  childPort.DevicePortNo,
+		Egress:   childPort.DevicePortNo,
+	}}, nil
+}